@@ -2,4 +2,14 @@ module github.com/vaibhavyadav-dev/vy-cli
 
 go 1.18
 
-require github.com/joho/godotenv v1.5.1
+require (
+	filippo.io/age v1.0.0
+	github.com/joho/godotenv v1.5.1
+	github.com/pelletier/go-toml/v2 v2.0.9
+	golang.org/x/term v0.10.0
+)
+
+require (
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+)
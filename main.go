@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/vaibhavyadav-dev/vy-cli/src"
 	"github.com/vaibhavyadav-dev/vy-cli/src/sysconfig"
@@ -57,9 +59,162 @@ func main() {
 	case "date":
 		fmt.Println(cmd.Date())
 	case "backup":
-		drive := "gdrive:"
-		verbose := false
-		folder := ""
+		if len(os.Args) > 2 && os.Args[2] == "keygen" {
+			cmd.HandleKeygen()
+			return
+		}
+
+		if len(os.Args) > 2 && os.Args[2] == "prune" {
+			policy := cmd.DefaultRetention
+			drive := "gdrive:"
+			dryRun, verbose := false, false
+
+			for i := 3; i < len(os.Args); i++ {
+				if os.Args[i] == "-v" {
+					verbose = true
+					continue
+				}
+				if os.Args[i] == "-n" || os.Args[i] == "--dry-run" {
+					dryRun = true
+					continue
+				}
+				if i+1 >= len(os.Args) {
+					continue
+				}
+
+				// Retention counts, e.g. --keep-daily 7
+				keep := map[string]*int{
+					"--keep-last":    &policy.Last,
+					"--keep-daily":   &policy.Daily,
+					"--keep-weekly":  &policy.Weekly,
+					"--keep-monthly": &policy.Monthly,
+				}
+				if n, ok := keep[os.Args[i]]; ok {
+					value, err := strconv.Atoi(os.Args[i+1])
+					if err != nil || value < 0 {
+						fmt.Printf("Invalid value for %s: %s\n", os.Args[i], os.Args[i+1])
+						os.Exit(1)
+					}
+					*n = value
+					i++
+					continue
+				}
+				if os.Args[i] == "-d" {
+					drive = cmd.NormalizeDrive(os.Args[i+1])
+					i++
+				}
+			}
+
+			fmt.Println("Selected Drive: ", drive)
+			cmd.HandlePrune(policy, drive, dryRun, verbose)
+			return
+		}
+
+		if len(os.Args) > 2 && os.Args[2] == "schedule" {
+			if len(os.Args) > 3 && os.Args[3] == "status" {
+				cmd.HandleScheduleStatus()
+				return
+			}
+			if len(os.Args) > 3 && os.Args[3] == "remove" {
+				cmd.HandleScheduleRemove()
+				return
+			}
+
+			// Everything but --every is passed on to the scheduled vy backup, e.g. -d or -p
+			every := ""
+			var backupArgs []string
+			for i := 3; i < len(os.Args); i++ {
+				if os.Args[i] == "--every" && i+1 < len(os.Args) {
+					every = os.Args[i+1]
+					i++
+					continue
+				}
+				backupArgs = append(backupArgs, os.Args[i])
+			}
+			cmd.HandleSchedule(every, backupArgs)
+			return
+		}
+
+		if len(os.Args) > 2 && os.Args[2] == "diff" {
+			opts := cmd.DiffOptions{Drive: "gdrive:"}
+			for i := 3; i < len(os.Args); i++ {
+				switch {
+				case os.Args[i] == "-v":
+					opts.Verbose = true
+				case os.Args[i] == "-d" && i+1 < len(os.Args):
+					opts.Drive = cmd.NormalizeDrive(os.Args[i+1])
+					i++
+				case os.Args[i] == "-p" && i+1 < len(os.Args):
+					opts.Profile = os.Args[i+1]
+					i++
+				case os.Args[i] == "-s" && i+1 < len(os.Args):
+					opts.Snapshot = os.Args[i+1]
+					i++
+				case os.Args[i] == "-e" && i+1 < len(os.Args):
+					opts.Entries = append(opts.Entries, strings.Split(os.Args[i+1], ",")...)
+					i++
+				}
+			}
+			cmd.HandleDiff(opts)
+			return
+		}
+
+		if len(os.Args) > 2 && os.Args[2] == "verify" {
+			opts := cmd.VerifyOptions{Drive: "gdrive:"}
+			for i := 3; i < len(os.Args); i++ {
+				switch {
+				case os.Args[i] == "-v":
+					opts.Verbose = true
+				case os.Args[i] == "-d" && i+1 < len(os.Args):
+					opts.Drive = cmd.NormalizeDrive(os.Args[i+1])
+					i++
+				case os.Args[i] == "-p" && i+1 < len(os.Args):
+					opts.Profile = os.Args[i+1]
+					i++
+				case os.Args[i] == "-s" && i+1 < len(os.Args):
+					opts.Snapshot = os.Args[i+1]
+					i++
+				case os.Args[i] == "--host" && i+1 < len(os.Args):
+					opts.Host = os.Args[i+1]
+					i++
+				}
+			}
+
+			// Non-zero on drift, so it can run from cron
+			if !cmd.HandleVerify(opts) {
+				os.Exit(1)
+			}
+			return
+		}
+
+		// hosts is list --hosts
+		if len(os.Args) > 2 && (os.Args[2] == "list" || os.Args[2] == "hosts") {
+			opts := cmd.ListOptions{Drive: "gdrive:", Hosts: os.Args[2] == "hosts"}
+			for i := 3; i < len(os.Args); i++ {
+				switch {
+				case os.Args[i] == "--hosts":
+					opts.Hosts = true
+				case os.Args[i] == "--json":
+					opts.JSON = true
+				case os.Args[i] == "-d" && i+1 < len(os.Args):
+					opts.Drive = cmd.NormalizeDrive(os.Args[i+1])
+					i++
+				case os.Args[i] == "--host" && i+1 < len(os.Args):
+					opts.Host = os.Args[i+1]
+					i++
+				case os.Args[i] == "-s" && i+1 < len(os.Args):
+					opts.Snapshot = os.Args[i+1]
+					i++
+				case os.Args[i] == "-e" && i+1 < len(os.Args):
+					opts.Entry = os.Args[i+1]
+					i++
+				}
+			}
+			cmd.HandleList(opts)
+			return
+		}
+
+		opts := cmd.BackupOptions{Jobs: 4, Retries: -1}
 
 		for i := 0; i < len(os.Args); i++ {
 
@@ -71,24 +226,126 @@ func main() {
 					fmt.Printf("Error getting current directory: %v\n", err)
 					return
 				}
-				folder = fmt.Sprintf("%s/%s", currentDir, os.Args[i+1])
+				opts.Folder = fmt.Sprintf("%s/%s", currentDir, os.Args[i+1])
 				continue
 			}
 
 			if os.Args[i] == "-v" {
-				verbose = true
+				opts.Verbose = true
 				continue;
 			}
 
+			if os.Args[i] == "--full" {
+				opts.Full = true
+				continue
+			}
+
+			if os.Args[i] == "-n" || os.Args[i] == "--dry-run" {
+				opts.DryRun = true
+				continue
+			}
+
+			if os.Args[i] == "--json" {
+				opts.JSON = true
+				continue
+			}
+
+			if i+1 < len(os.Args) && os.Args[i] == "--secrets" {
+				opts.Secrets = os.Args[i+1]
+				continue
+			}
+
+			if i+1 < len(os.Args) && os.Args[i] == "--bwlimit" {
+				opts.BWLimit = os.Args[i+1]
+				continue
+			}
+
+			if i+1 < len(os.Args) && os.Args[i] == "--retries" {
+				retries, err := strconv.Atoi(os.Args[i+1])
+				if err != nil || retries < 0 {
+					fmt.Printf("Invalid number of retries: %s\n", os.Args[i+1])
+					os.Exit(1)
+				}
+				opts.Retries = retries
+				continue
+			}
+
+			if i+1 < len(os.Args) && os.Args[i] == "--timeout" {
+				timeout, err := time.ParseDuration(os.Args[i+1])
+				if err != nil || timeout <= 0 {
+					fmt.Printf("Invalid timeout: %s (e.g. 90m or 2h)\n", os.Args[i+1])
+					os.Exit(1)
+				}
+				opts.Timeout = timeout
+				continue
+			}
+
+			if os.Args[i] == "--archive" {
+				// The format is optional, tar.gz unless zst is asked for
+				opts.Archive = "gz"
+				if i+1 < len(os.Args) && (os.Args[i+1] == "gz" || os.Args[i+1] == "zst") {
+					opts.Archive = os.Args[i+1]
+					i++
+				}
+				continue
+			}
+
+			// Repeat -d to back up to several drives in one run
 			if i+1 < len(os.Args) && os.Args[i] == "-d" {
-				drive = fmt.Sprintf("%s:", os.Args[i+1])
+				opts.Drives = append(opts.Drives, cmd.NormalizeDrive(os.Args[i+1]))
 				continue;	
 			}
+
+			if i+1 < len(os.Args) && os.Args[i] == "-p" {
+				opts.Profile = os.Args[i+1]
+				continue
+			}
+
+			if i+1 < len(os.Args) && (os.Args[i] == "-j" || os.Args[i] == "--jobs") {
+				jobs, err := strconv.Atoi(os.Args[i+1])
+				if err != nil || jobs < 1 {
+					fmt.Printf("Invalid number of jobs: %s\n", os.Args[i+1])
+					os.Exit(1)
+				}
+				opts.Jobs = jobs
+				continue
+			}
 		}
-		
-		fmt.Println("Selected Drive: ", drive)
-		fmt.Println("Selected Drive: ", folder)
-		cmd.HandleBackup(verbose, folder, drive)
+
+		// The destination is printed by HandleBackup, once for each of them
+		os.Exit(cmd.HandleBackup(opts))
+	case "restore":
+		opts := cmd.RestoreOptions{Drive: "gdrive:", Mode: cmd.RestoreBackup}
+
+		for i := 2; i < len(os.Args); i++ {
+			switch {
+			case os.Args[i] == "-v":
+				opts.Verbose = true
+			case os.Args[i] == "-n" || os.Args[i] == "--dry-run":
+				opts.DryRun = true
+			case os.Args[i] == "-d" && i+1 < len(os.Args):
+				opts.Drive = cmd.NormalizeDrive(os.Args[i+1])
+				i++
+			case os.Args[i] == "-p" && i+1 < len(os.Args):
+				opts.Profile = os.Args[i+1]
+				i++
+			case os.Args[i] == "-s" && i+1 < len(os.Args):
+				opts.Snapshot = os.Args[i+1]
+				i++
+			case os.Args[i] == "-m" && i+1 < len(os.Args):
+				opts.Mode = os.Args[i+1]
+				i++
+			case os.Args[i] == "-e" && i+1 < len(os.Args):
+				opts.Entries = append(opts.Entries, strings.Split(os.Args[i+1], ",")...)
+				i++
+			case os.Args[i] == "--host" && i+1 < len(os.Args):
+				opts.Host = os.Args[i+1]
+				i++
+			}
+		}
+
+		fmt.Println("Selected Drive: ", opts.Drive)
+		os.Exit(cmd.HandleRestore(opts))
 	case "commit":
 		if len(os.Args) < 3{
 			fmt.Println("Please provide commit message")
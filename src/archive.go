@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Compression of the single archive written by vy backup --archive
+const (
+	archiveGzip = "gz"
+	archiveZstd = "zst" // needs the zstd command
+)
+
+// Name of the archive object inside the snapshot directory
+func archiveName(format string) string {
+	return "backup.tar." + format
+}
+
+// Check the --archive format and that it can be written on this machine
+func checkArchiveFormat(format string) error {
+	switch format {
+	case archiveGzip:
+		return nil
+	case archiveZstd:
+		if _, err := exec.LookPath("zstd"); err != nil {
+			return fmt.Errorf("zstd is not installed. Please install it using:\nsudo apt install zstd\nor use --archive gz")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown archive format: %s (use gz or zst)", format)
+}
+
+// Directory holding an entry's files: the entry itself, or the parent of a single file
+func entryRoot(path string) string {
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		return filepath.Dir(path)
+	}
+	return path
+}
+
+// A tarball that workers add whole entries to, one at a time
+type archiveWriter struct {
+	path string
+	file *os.File
+	zstd *exec.Cmd      // set for zst, compresses what is written to its stdin
+	comp io.WriteCloser // compressor the tar stream goes through
+	tw   *tar.Writer
+	mu   sync.Mutex
+}
+
+func newArchiveWriter(path, format string) (*archiveWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &archiveWriter{path: path, file: file}
+	if format == archiveZstd {
+		a.zstd = exec.Command("zstd", "-q", "-c", "-T0")
+		a.zstd.Stdout = file
+		var stderr bytes.Buffer
+		a.zstd.Stderr = &stderr
+		if a.comp, err = a.zstd.StdinPipe(); err == nil {
+			err = a.zstd.Start()
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("zstd error: %w\nError: %s", err, stderr.String())
+		}
+	} else {
+		a.comp = gzip.NewWriter(file)
+	}
+	a.tw = tar.NewWriter(a.comp)
+	return a, nil
+}
+
+// Add the listed files of an entry, relative to root, under the entry's remote name
+func (a *archiveWriter) addEntry(remote, root string, files []ManifestFile) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, file := range files {
+		if err := addTarFile(a.tw, filepath.Join(root, filepath.FromSlash(file.Path)), remote+"/"+file.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add a single local file as name
+func (a *archiveWriter) addFile(path, name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return addTarFile(a.tw, path, name)
+}
+
+// Add the manifest, so the archive can be restored on its own
+func (a *archiveWriter) addManifest(m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	header := &tar.Header{
+		Name:     manifestName,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = a.tw.Write(data)
+	return err
+}
+
+// Finish the archive, it is ready to upload once this returns
+func (a *archiveWriter) Close() error {
+	defer a.file.Close()
+
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if err := a.comp.Close(); err != nil {
+		return err
+	}
+	if a.zstd != nil {
+		if err := a.zstd.Wait(); err != nil {
+			return fmt.Errorf("zstd error: %w", err)
+		}
+	}
+	return a.file.Close()
+}
+
+// Unpack an archive written by archiveWriter into dir
+func extractArchive(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if !strings.HasSuffix(path, "."+archiveZstd) {
+		return extractTarGz(f, dir)
+	}
+
+	cmd := exec.Command("zstd", "-q", "-d", "-c")
+	cmd.Stdin = f
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("zstd is needed to restore this backup: %w", err)
+	}
+	if err := extractTar(stdout, dir); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	// Let zstd finish writing whatever follows the end of the tarball
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("zstd error: %w\nError: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// Find the archive of a snapshot written with --archive, empty if there is none
+func findArchive(store Storage, remoteDir string) string {
+	for _, format := range []string{archiveGzip, archiveZstd} {
+		if _, err := store.Stat(remoteDir + "/" + archiveName(format)); err == nil {
+			return archiveName(format)
+		}
+	}
+	return ""
+}
+
+// Download a snapshot's archive and unpack it into dir
+func fetchArchive(store Storage, remoteDir, name, dir string) error {
+	if err := store.Get(remoteDir+"/"+name, dir); err != nil {
+		return err
+	}
+	object := filepath.Join(dir, name)
+	defer os.Remove(object)
+	return extractArchive(object, dir)
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	for _, format := range []string{archiveGzip, archiveZstd} {
+		t.Run(format, func(t *testing.T) {
+			if _, err := exec.LookPath("zstd"); err != nil && format == archiveZstd {
+				t.Skip("zstd is not installed")
+			}
+			src := t.TempDir()
+			writeFiles(t, src, map[string]string{
+				"nvim/init.lua":  "vim.o.number = true\n",
+				"nvim/lua/x.lua": "return {}\n",
+				".bashrc":        "alias ll='ls -la'\n",
+				"ssh.tar.gz.age": "encrypted",
+			})
+			nvim, err := buildManifestEntry(BackupEntry{Remote: "nvim", Path: filepath.Join(src, "nvim")}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bash, err := buildManifestEntry(BackupEntry{Remote: "bash", Path: filepath.Join(src, ".bashrc")}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), archiveName(format))
+			a, err := newArchiveWriter(path, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := newManifest()
+			m.Entries = []ManifestEntry{nvim, bash}
+			for _, err := range []error{
+				a.addEntry("nvim", filepath.Join(src, "nvim"), nvim.Files),
+				a.addEntry("bash", entryRoot(filepath.Join(src, ".bashrc")), bash.Files),
+				a.addFile(filepath.Join(src, "ssh.tar.gz.age"), "ssh/ssh.tar.gz.age"),
+				a.addManifest(m),
+				a.Close(),
+			} {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			dir := t.TempDir()
+			if err := extractArchive(path, dir); err != nil {
+				t.Fatalf("extractArchive: %v", err)
+			}
+			for name, want := range map[string]string{
+				"nvim/init.lua":      "vim.o.number = true\n",
+				"nvim/lua/x.lua":     "return {}\n",
+				"bash/.bashrc":       "alias ll='ls -la'\n",
+				"ssh/ssh.tar.gz.age": "encrypted",
+			} {
+				if data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); err != nil || string(data) != want {
+					t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+				}
+			}
+			got, err := readManifest(filepath.Join(dir, manifestName))
+			if err != nil || len(got.Entries) != 2 || got.Snapshot != m.Snapshot {
+				t.Errorf("manifest in the archive = %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestExtractTar(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"entry/file", true},
+		{"entry/sub/../file", true},
+		{"../evil", false},
+		{"entry/../../evil", false},
+		{"entry/../../dir-evil/file", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			if err := tw.WriteHeader(&tar.Header{Name: tt.name, Mode: 0644, Size: 4, Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write([]byte("data"))
+			tw.Close()
+
+			root := t.TempDir()
+			dir := filepath.Join(root, "dir")
+			err := extractTar(&buf, dir)
+			if (err == nil) != tt.ok {
+				t.Fatalf("extractTar(%q) error = %v, want ok %v", tt.name, err, tt.ok)
+			}
+			if tt.ok {
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "invalid path") {
+				t.Errorf("error = %v, want invalid path", err)
+			}
+			// Nothing may be written next to dir
+			if entries, _ := os.ReadDir(root); len(entries) > 0 {
+				t.Errorf("files written outside dir: %v", entries)
+			}
+		})
+	}
+}
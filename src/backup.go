@@ -1,41 +1,21 @@
 package cmd
 
 import (
-	"bytes"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
-func HandleBackup(verbose bool, absLocalFilePath, drive string) {
+// Define backup directory on OneDrive
+const backupDir = "Backups/ubuntu-settings"
 
-	// Check if the localFilePath is a empty string
-	// If it is, upload the whole folder and it's content and return
-	isFolder := len(strings.TrimSpace(absLocalFilePath)) > 0
-	if isFolder {
-		uploadFolder(absLocalFilePath, drive, verbose)
-		return;
-	}		
-
-
-	// Check if rclone is installed and configured														
-	err := checkRcloneInstallation(drive)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Printf("Error getting home directory: %v\n", err)
-		return
-	}
-
-	filesToBackup := make(map[string]string)
-	possibleFiles := map[string]string{
+// Configurations backed up by default, keyed by their name on the remote
+func backupTargets(homeDir string) map[string]string {
+	return map[string]string{
 		// Terminal and shell settings
 		".bashrc":       filepath.Join(homeDir, ".bashrc"),
 		".profile":      filepath.Join(homeDir, ".profile"),
@@ -45,7 +25,6 @@ func HandleBackup(verbose bool, absLocalFilePath, drive string) {
 		"custom-scripts": filepath.Join(homeDir, "bin"), // Custom scripts directory
 		
 		// Desktop environment settings
-		"dconf-settings": filepath.Join(homeDir, ".config/dconf"),
 		"gnome-terminal": filepath.Join(homeDir, ".config/gnome-terminal"),
 		"gtk-3.0":       filepath.Join(homeDir, ".config/gtk-3.0"),
 		"gtk-4.0":       filepath.Join(homeDir, ".config/gtk-4.0"),
@@ -84,115 +63,595 @@ func HandleBackup(verbose bool, absLocalFilePath, drive string) {
 		// Systemd user services
 		"systemd-user":  filepath.Join(homeDir, ".config/systemd/user"),
 	}
-	
-	for name, path := range possibleFiles {
-		if _, err := os.Stat(path); err == nil {
-			filesToBackup[name] = path
+}
+
+type BackupOptions struct {
+	Verbose bool
+	Folder  string        // absolute path of a single folder to upload instead of the profile
+	Drives  []string      // destinations from -d, empty means the ones in the config
+	Drive   string        // destination being backed up to, set for each of Drives
+	Profile string        // backup profile to use, empty means the default
+	Full    bool          // upload every entry, even the ones unchanged since the last backup
+	Jobs    int           // number of entries uploaded at the same time
+	Archive string        // upload everything as one archive compressed with this format, gz or zst
+	DryRun  bool          // print what would be uploaded without contacting the drive
+	JSON    bool          // print a BackupReport on stdout, everything else goes to stderr
+	Secrets string        // what to do with secrets found in the files: warn, block or redact, empty means the config's
+	Retries int           // attempts after the first one for remote operations that may succeed later, -1 means the config's
+	BWLimit string        // rclone --bwlimit, empty means the config's
+	Timeout time.Duration // for the whole run, 0 means the config's or none
+}
+
+// Run a backup to every destination in turn and return the exit code for vy
+func HandleBackup(opts BackupOptions) int {
+	report := newBackupReport("")
+	// Only the report goes to stdout with --json, what is printed along the way goes to stderr
+	var out io.Writer = os.Stdout
+	if opts.JSON {
+		out = os.Stderr
+	}
+	code := backupToDestinations(opts, report, out)
+
+	if opts.JSON {
+		if err := report.print(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+		}
+	}
+	return code
+}
+
+// Fill report with the result of each destination, or with that of the only one
+func backupToDestinations(opts BackupOptions, report *BackupReport, out io.Writer) int {
+	failed := func(err error) int {
+		fmt.Fprintln(out, err)
+		report.Error = err.Error()
+		report.finish(ExitFailed)
+		return ExitFailed
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return failed(fmt.Errorf("Error getting home directory: %w", err))
+	}
+	config, err := loadBackupConfig(homeDir)
+	if err != nil {
+		return failed(err)
+	}
+	drives := backupDestinations(opts.Drives, config)
+	if len(drives) == 1 {
+		report.Drive = drives[0]
+	}
+
+	// The timeout covers every destination
+	transfer, cancel, err := newTransfer(opts, config.Transfer)
+	if err != nil {
+		return failed(err)
+	}
+	defer cancel()
+	transfer.log = out
+
+	host, err := currentHost(config.Host)
+	if err != nil {
+		return failed(err)
+	}
+	local, err := newBackupLocal(transfer, host)
+	if err != nil {
+		return failed(err)
+	}
+	defer local.Close()
+	local.out = out
+
+	// The profile is resolved once, every destination backs up the same entries
+	var entries []BackupEntry
+	if strings.TrimSpace(opts.Folder) == "" {
+		if entries, err = config.profileEntries(opts.Profile, homeDir); err != nil {
+			return failed(err)
+		}
+	}
+
+	// Hooks run once for the whole run, a folder or a dry run has none
+	var hooks Hooks
+	if strings.TrimSpace(opts.Folder) == "" && !opts.DryRun {
+		if hooks, err = config.profileHooks(opts.Profile); err != nil {
+			return failed(err)
+		}
+	}
+	env := []string{
+		"VY_PROFILE=" + config.profileName(opts.Profile),
+		"VY_HOST=" + host,
+		"VY_SNAPSHOT=" + local.started.Snapshot,
+		"VY_DRIVES=" + strings.Join(drives, " "),
+	}
+	local.hooks = newHookRun(transfer.ctx, homeDir, hooks, env, opts.Verbose, out)
+
+	code := ExitFailed
+	if err := local.hooks.pre(); err != nil {
+		fmt.Fprintln(out, err)
+		report.Error = err.Error()
+	} else if len(drives) == 1 {
+		fmt.Fprintln(out, "Selected Drive: ", drives[0])
+		opts.Drive = drives[0]
+		code = runBackup(opts, config, entries, local, report)
+	} else {
+		code = backupToEach(drives, opts, config, entries, local, report)
+	}
+
+	// Post hooks run whatever happened, e.g. to start a daemon a pre hook stopped
+	local.hooks.post(code, report.failedEntries())
+	report.Hooks = local.hooks.reports
+	report.finish(code)
+	return code
+}
+
+func backupToEach(drives []string, opts BackupOptions, config *BackupConfig, entries []BackupEntry, local *backupLocal, report *BackupReport) int {
+	out := local.out
+	for _, drive := range drives {
+		fmt.Fprintf(out, "\n=== %s ===\n", drive)
+		opts.Drive = drive
+		destination := newBackupReport(drive)
+		destination.finish(runBackup(opts, config, entries, local, destination))
+		report.Destinations = append(report.Destinations, destination)
+		report.Bytes += destination.Bytes
+	}
+	report.Entries = nil
+	printDestinations(out, report.Destinations)
+	return combinedExitCode(report.Destinations)
+}
+
+// Back up the entries of the profile, or opts.Folder, to opts.Drive
+func runBackup(opts BackupOptions, config *BackupConfig, entries []BackupEntry, local *backupLocal, report *BackupReport) int {
+	verbose, drive, out := opts.Verbose, opts.Drive, local.out
+	failed := func(code int, err error) int {
+		fmt.Fprintln(out, err)
+		report.Error = err.Error()
+		return code
+	}
+
+	if opts.DryRun && strings.TrimSpace(opts.Folder) != "" {
+		planFolder(out, opts.Folder, drive, verbose)
+		return ExitSuccess
+	}
+	if opts.DryRun {
+		if err := planBackup(out, opts, config, entries); err != nil {
+			return failed(ExitFailed, err)
+		}
+		return ExitSuccess
+	}
+
+	// Check if rclone is installed and configured, or the backup directory exists
+	store, err := openStorage(drive, local.transfer)
+	if err != nil {
+		return failed(ExitNoRemote, err)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return failed(ExitFailed, fmt.Errorf("Error getting home directory: %w", err))
+	}
+
+	// Check if the localFilePath is a empty string
+	// If it is, upload the whole folder and it's content and return
+	isFolder := len(strings.TrimSpace(opts.Folder)) > 0
+	if isFolder {
+		// A folder that can't be read fails in uploadFolder
+		if target, err := folderEntry(opts.Folder); err == nil {
+			report.Secrets, err = local.scanFolder(target, config.Secrets, opts.Secrets, homeDir)
+			if err != nil {
+				return failed(ExitFailed, err)
+			}
+		}
+		result := uploadFolder(opts.Folder, store, local, verbose)
+		report.add(result)
+		report.Error = result.entry.Error
+		return report.exitCode()
+	}		
+
+
+	// An archive holds every entry, so nothing can be skipped as unchanged
+	if opts.Archive != "" {
+		if err := checkArchiveFormat(opts.Archive); err != nil {
+			return failed(ExitFailed, err)
+		}
+		opts.Full = true
+	}
+
+	// Entries unchanged since the last backup are not uploaded again,
+	// as long as the snapshot holding them is still on the drive
+	state := loadBackupState(homeDir)
+	if !opts.Full {
+		snapshots, err := listSnapshots(store, hostRoot(local.host))
+		if err != nil {
+			snapshots = nil
+		}
+		state.forgetMissing(drive, snapshots)
+	}
+
+	// Pre hooks may write what an entry backs up, so they run before anything is read
+	if err := local.hooks.preEntries(entries); err != nil {
+		return failed(ExitFailed, err)
+	}
+	local.dump(entries, verbose)
+
+	// Optional entries that don't exist on this machine are left out silently
+	var filesToBackup []BackupEntry
+	for _, entry := range entries {
+		if reason, ok := local.hooks.skipped[entry.Remote]; ok {
+			fmt.Fprintf(out, "  ⏭️  Skipping %s: %s\n\n", entry.Name, reason)
+			report.add(entryResult{entry: ManifestEntry{Name: entry.Remote, Error: reason}, status: entrySkipped})
+			continue
+		}
+		if _, err := os.Stat(entry.Path); err == nil || !entry.Optional {
+			filesToBackup = append(filesToBackup, entry)
 		}
 	}
 	
 
-	// Define backup directory on OneDrive
-	backupDir := "Backups/ubuntu-settings"
+	// Look for secrets before anything leaves the machine, files are hashed here once for the whole run
+	hash := func(target BackupEntry) (ManifestEntry, error) {
+		var previousEntry *ManifestEntry
+		if previous := state.lookup(drive, target.Remote); previous != nil {
+			previousEntry = &previous.Entry
+		}
+		return local.hash(target, previousEntry)
+	}
+	err = local.scanSecrets(filesToBackup, config.Secrets, opts.Secrets, homeDir, hash)
+	report.Secrets = orderedFindings(filesToBackup, local.secrets)
+	if err != nil {
+		return failed(ExitFailed, err)
+	}
 
 	// Track backup status
-	successCount := 0
+	successCount, unchangedCount := 0, 0
 	totalFiles := len(filesToBackup)
+	manifest := local.manifest()
+	report.Snapshot = manifest.Snapshot
+	if local.encrypt == nil {
+		local.encrypt = &encryptor{config: config.Encryption}
+	}
+
+	run := &backupRun{
+		opts:        opts,
+		store:       store,
+		snapshotDir: hostRoot(local.host) + "/" + manifest.Snapshot,
+		local:       local,
+	}
+	snapshotDir := run.snapshotDir
+	if err := store.Mkdir(snapshotDir); err != nil {
+		return failed(ExitFailed, fmt.Errorf("Error creating snapshot directory: %w", err))
+	}
+	if opts.Archive != "" {
+		manifest.Archive = archiveName(opts.Archive)
+		if err := local.startArchive(opts.Archive); err != nil {
+			return failed(ExitFailed, fmt.Errorf("Error creating archive: %w", err))
+		}
+		run.archive = local.archive
+	}
 	
-	fmt.Printf("Please wait.... I'm Uploading files to %s.....\nThis Will Take Time Depending Upon Speed of Internet and Size of Folder :) ...", drive)
+	fmt.Fprintf(out, "Please wait.... I'm Uploading files to %s.....\nThis Will Take Time Depending Upon Speed of Internet and Size of Folder :) ...\n", drive)
 	if(verbose){
-		fmt.Println("Starting Ubuntu settings backup...")
-		fmt.Printf("Total configurations to backup: %d\n\n", totalFiles)
+		fmt.Fprintln(out, "Starting Ubuntu settings backup...")
+		fmt.Fprintf(out, "Total configurations to backup: %d\n\n", totalFiles)
 	}
-	
-	// Process each file individually
-	for name, path := range filesToBackup {
-		if _, err := os.Stat(path); err != nil {
-			fmt.Printf("  ❌ Skipping: configuration not found\n\n")
-			continue
+
+	// Workers only read the state, it is updated here as results come in
+	previous := make([]*stateEntry, len(filesToBackup))
+	for i, target := range filesToBackup {
+		previous[i] = state.lookup(drive, target.Remote)
+	}
+
+	// Bytes to upload for the progress, entries unchanged since the last backup don't count
+	var total int64
+	needKey := false
+	for i, target := range filesToBackup {
+		entry, err := hash(target)
+		changed := err != nil || opts.Full || previous[i] == nil || !previous[i].unchanged(entry, config.Encryption.keysFor(target))
+		if changed && err == nil {
+			total += entry.Size
+		}
+		needKey = needKey || changed && target.encrypted()
+	}
+	// Ask for the passphrase before the workers start, the progress would draw over the prompt
+	if needKey {
+		if err := local.encrypt.setup(); err != nil {
+			return failed(ExitFailed, err)
+		}
+	}
+	// An archive is only uploaded once it is complete
+	if run.archive == nil {
+		run.progress = newProgress(out, total)
+	}
+
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	results := make([]chan entryResult, len(filesToBackup))
+	for i := range results {
+		results[i] = make(chan entryResult, 1)
+	}
+	// The entries may already be in the archive built for an earlier destination
+	reuseArchive := run.archive != nil && local.archived != nil
+	if reuseArchive {
+		for i, result := range local.archived {
+			results[i] <- result
 		}
+	} else {
+		queue := make(chan int)
+		for w := 0; w < jobs; w++ {
+			go func() {
+				for i := range queue {
+					start := time.Now()
+					result := run.backupEntry(filesToBackup[i], previous[i])
+					result.duration = time.Since(start)
+					results[i] <- result
+				}
+			}()
+		}
+		go func() {
+			for i := range filesToBackup {
+				queue <- i
+			}
+			close(queue)
+		}()
+	}
 
-		if(verbose){
-			fmt.Printf("📤 Uploading %s to %s... ", name, drive)
+	// Print results in profile order, whichever worker finishes first
+	for i := range filesToBackup {
+		result := <-results[i]
+		run.print(result.log)
+		report.add(result)
+		if run.archive != nil && !reuseArchive {
+			local.archived = append(local.archived, result)
 		}
 
-		err = rclone(path, filepath.Join(backupDir, name), drive)
-		if err != nil {
-			fmt.Printf("❌ Failed\n  Error: %v\n\n", err)
+		switch result.status {
+		case entryMissing:
 			continue
+		case entryUnchanged:
+			unchangedCount++
+			successCount++
+		case entryUploaded:
+			// Entries inside an archive can't be pointed at by later snapshots
+			if run.archive == nil {
+				state.record(drive, manifest.Snapshot, result.entry)
+			}
+			successCount++
 		}
+		manifest.Entries = append(manifest.Entries, result.entry)
+	}
+
+	run.progress.close()
 
-		if(verbose){
-			fmt.Printf("✅ Success\n\n")
+	sort.Slice(manifest.Entries, func(i, j int) bool {
+		return manifest.Entries[i].Name < manifest.Entries[j].Name
+	})
+	if run.archive != nil {
+		if err := run.uploadArchive(manifest); err != nil {
+			fmt.Fprintf(out, "❌ Failed to upload archive\n  Error: %v\n\n", err)
+			report.Error = err.Error()
+			return ExitFailed
 		}
+	}
+	code := report.exitCode()
+	if err := uploadManifest(store, manifest, snapshotDir, manifestName); err != nil {
+		fmt.Fprintf(out, "❌ Failed to upload manifest\n  Error: %v\n\n", err)
+		// The entries are there, but restore and verify can't find them by hash
+		report.Error = err.Error()
+		if code == ExitSuccess {
+			code = ExitPartial
+		}
+	} else if err := state.save(homeDir); err != nil {
+		fmt.Fprintf(out, "⚠️  Could not save backup state: %v\n", err)
+	}
 
-		successCount++
+	fmt.Fprintf(out, "Backup completed! Successfully backed up %d of %d configurations to snapshot %s\n", successCount, totalFiles, manifest.Snapshot)
+	if unchangedCount > 0 {
+		fmt.Fprintf(out, "%d unchanged configurations were not uploaded again (use --full to force)\n", unchangedCount)
 	}
+	return code
+}
+
+// What happened to a single entry during a backup run
+const (
+	entryUploaded  = "uploaded"
+	entryUnchanged = "unchanged"
+	entryFailed    = "failed"
+	entryMissing   = "missing"
+	entrySkipped   = "skipped" // left out by a failing pre hook
+)
 
-	fmt.Printf("Backup completed! Successfully backed up %d of %d configurations\n", successCount, totalFiles)
+type entryResult struct {
+	entry    ManifestEntry
+	status   string
+	log      string // output for the entry, printed once it is done so workers don't interleave
+	duration time.Duration
 }
 
-// Upload a folder to the specified drive using rclone
-func uploadFolder(folder, drive string, verbose bool) {
-	if verbose {
-		fmt.Printf("📤 Uploading folder (Local) %s to %s...\n", folder, drive)
+// Settings shared by the workers of one backup run
+type backupRun struct {
+	opts        BackupOptions
+	store       Storage
+	snapshotDir string
+	local       *backupLocal
+	archive     *archiveWriter // set with --archive, entries are added to it instead of uploaded
+	progress    *progress      // of the uploads, nil with --archive
+}
+
+// Hash, encrypt if needed and upload a single entry, unless it is unchanged since previous
+// Print the output of an entry, above the progress when there is one
+func (r *backupRun) print(s string) {
+	if r.progress != nil {
+		r.progress.print(s)
+		return
 	}
+	fmt.Fprint(r.local.out, s)
+}
 
-	folderName := filepath.Base(folder)
-	remoteDir := filepath.Join("Backups", folderName)
-	
-	err := rclone(folder, remoteDir, drive)
+func (r *backupRun) backupEntry(target BackupEntry, previous *stateEntry) entryResult {
+	var log strings.Builder
+	drive := r.opts.Drive
+
+	if _, err := os.Stat(target.Path); err != nil {
+		fmt.Fprintf(&log, "  ❌ Skipping %s: configuration not found\n\n", target.Name)
+		entry := ManifestEntry{Name: target.Remote, Error: "configuration not found"}
+		return entryResult{entry: entry, status: entryMissing, log: log.String()}
+	}
+
+	if r.opts.Verbose && r.archive != nil {
+		fmt.Fprintf(&log, "📦 Archiving %s... ", target.Name)
+	} else if r.opts.Verbose {
+		fmt.Fprintf(&log, "📤 Uploading %s to %s... ", target.Name, drive)
+	}
+	failed := func(entry ManifestEntry, err error) entryResult {
+		fmt.Fprintf(&log, "❌ Failed\n  Error: %v\n\n", err)
+		entry.Error = err.Error()
+		return entryResult{entry: entry, status: entryFailed, log: log.String()}
+	}
+
+	var previousEntry *ManifestEntry
+	if previous != nil {
+		previousEntry = &previous.Entry
+	}
+
+	entry, err := r.local.hash(target, previousEntry)
 	if err != nil {
-		fmt.Printf("❌ Failed to upload folder (Local): %v\n", err)
-		return
+		return failed(entry, err)
 	}
 
+	// With --secrets redact, a copy without them is uploaded instead and compared with the last one
+	target, entry, err = r.local.redact(target, entry)
+	if err != nil {
+		return failed(entry, err)
+	}
 
-	if verbose {
-		fmt.Printf("✅ Successfully uploaded folder (Local) %s\n", folder)
+	if !r.opts.Full && previous != nil && previous.unchanged(entry, r.local.encrypt.config.keysFor(target)) {
+		// Point at the snapshot that already holds the data
+		entry.Encryption = previous.Entry.Encryption
+		entry.StoredIn = previous.Snapshot
+		if r.opts.Verbose {
+			fmt.Fprintf(&log, "⏭️  Unchanged since %s\n\n", previous.Snapshot)
+		}
+		return entryResult{entry: entry, status: entryUnchanged, log: log.String()}
 	}
-}
 
-// Upload a file to the specified drive using rclone
-			// localpath:  //remotePath		//driveName (oneDrive, googleDrive e.t.c)
-func rclone(localFilePath, remotePath, drive string) error {
-	// Check if the destination directory exists, if not create it
-	checkCmd := exec.Command("rclone", "lsf", drive+filepath.Dir(remotePath))
-	if err := checkCmd.Run(); err != nil {
-		mkdirCmd := exec.Command("rclone", "mkdir", drive+filepath.Dir(remotePath))
-		if err := mkdirCmd.Run(); err != nil {
-			return fmt.Errorf("failed to create directory: %w", err)
+	// Retries are part of the entry's output, not printed in the middle of another one
+	store := logRetries(r.store, &log)
+	progress := r.progress.entry(target.Name)
+	if target.encrypted() {
+		var object string
+		object, entry.Encryption, err = r.local.encryptEntry(target, entry.Files)
+		if err == nil && r.archive != nil {
+			err = r.archive.addFile(object, target.Remote+"/"+entry.Encryption.Object)
+		} else if err == nil {
+			err = putProgress(store, object, r.snapshotDir+"/"+target.Remote, Filter{}, progress)
 		}
+	} else if r.archive != nil {
+		err = r.archive.addEntry(target.Remote, entryRoot(target.Path), entry.Files)
+	} else {
+		err = putProgress(store, target.Path, r.snapshotDir+"/"+target.Remote, target.filter(), progress)
+	}
+	r.progress.finish(target.Name, entry.Size)
+	if err != nil {
+		return failed(entry, err)
 	}
 
-	cmd := exec.Command("rclone", "copy", localFilePath, drive+remotePath, "--progress")
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	if r.opts.Verbose {
+		fmt.Fprintf(&log, "✅ Success\n\n")
+	}
+	return entryResult{entry: entry, status: entryUploaded, log: log.String()}
+}
 
-	err := cmd.Run()
+// dconf settings, package lists and exports are dumped to a file first, then backed up like any other entry
+func dumpGenerated(out io.Writer, entries []BackupEntry, verbose bool) {
+	for _, entry := range entries {
+		var err error
+		switch {
+		case len(entry.Dconf) > 0:
+			err = dumpDconf(entry.Dconf, entry.Path)
+		case len(entry.Packages) > 0:
+			err = dumpPackages(entry.Packages, entry.Path)
+		case entry.Export != "":
+			err = dumpExport(entry.Export, entry.Path)
+		default:
+			continue
+		}
+		if err != nil {
+			// Without a dump the entry counts as missing, rather than uploading an old one.
+			// Only the file vy wrote goes, never anything else in the directory.
+			os.Remove(filepath.Join(entry.Path, entry.dumpFile()))
+			if !entry.Optional || verbose {
+				fmt.Fprintf(out, "⚠️  Could not dump %s: %v\n", entry.Name, err)
+			}
+		}
+	}
+}
+
+// Finish the archive with the manifest inside, if an earlier destination didn't, and upload it to the snapshot
+func (r *backupRun) uploadArchive(manifest *Manifest) error {
+	out := r.local.out
+	if r.opts.Verbose {
+		fmt.Fprintf(out, "📤 Uploading %s to %s...\n", manifest.Archive, r.opts.Drive)
+	}
+	if err := r.local.finishArchive(manifest); err != nil {
+		return err
+	}
+	info, err := os.Stat(r.archive.path)
+	if err != nil {
+		return err
+	}
+	progress := newProgress(out, info.Size())
+	err = putProgress(logRetries(r.store, progress), r.archive.path, r.snapshotDir, Filter{}, progress.entry(manifest.Archive))
+	progress.close()
 	if err != nil {
-		return fmt.Errorf("rclone error: %w\nOutput: %s\nError: %s",
-			err, stdout.String(), stderr.String())
+		return err
+	}
+	if r.opts.Verbose {
+		fmt.Fprintf(out, "✅ Success\n\n")
 	}
 	return nil
 }
 
-// Check if rclone is installed and configured with the specified drive
-func checkRcloneInstallation(drive string) error {
-	// Check if rclone is installed
-	_, err := exec.LookPath("rclone")
+// Upload a folder to the specified drive
+func uploadFolder(folder string, store Storage, local *backupLocal, verbose bool) entryResult {
+	out := local.out
+	if verbose {
+		fmt.Fprintf(out, "📤 Uploading folder (Local) %s to %s...\n", folder, store)
+	}
+
+	start := time.Now()
+	folderName := filepath.Base(folder)
+	remoteDir := "Backups/" + folderName
+
+	target, err := folderEntry(folder)
+	var entry ManifestEntry
+	if err == nil {
+		entry, err = local.hash(target, nil)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "❌ Failed to read folder (Local): %v\n", err)
+		entry.Error = err.Error()
+		return entryResult{entry: entry, status: entryFailed, duration: time.Since(start)}
+	}
+	
+	progress := newProgress(out, entry.Size)
+	err = putProgress(logRetries(store, progress), folder, remoteDir, target.filter(), progress.entry(folderName))
+	progress.close()
 	if err != nil {
-		return fmt.Errorf("rclone is not installed. Please install it using:\ncurl https://rclone.org/install.sh | sudo bash\nThen authenticate with: rclone config")
+		fmt.Fprintf(out, "❌ Failed to upload folder (Local): %v\n", err)
+		entry.Error = err.Error()
+		return entryResult{entry: entry, status: entryFailed, duration: time.Since(start)}
 	}
 
-	// Check if rclone is configured with drive or not
-	cmd := exec.Command("rclone", "listremotes")
-	output, err := cmd.Output()
-	if err != nil || !bytes.Contains(output, []byte(drive)) {
-		return fmt.Errorf("rclone is not configured with %s.\nPlease run 'rclone config' and set up your OneDrive connection", drive)
+	// The folder is uploaded as-is, so its manifest sits beside it instead of inside
+	manifest := newManifest()
+	manifest.Entries = append(manifest.Entries, entry)
+	if err := uploadManifest(store, manifest, "Backups", folderName+"."+manifestName); err != nil {
+		fmt.Fprintf(out, "❌ Failed to upload manifest: %v\n", err)
 	}
-	return nil
-}
 
 
+	if verbose {
+		fmt.Fprintf(out, "✅ Successfully uploaded folder (Local) %s\n", folder)
+	}
+	return entryResult{entry: entry, status: entryUploaded, duration: time.Since(start)}
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Back up a profile to a local directory, lose the files and restore them
+func TestBackupAndRestore(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive string
+	}{
+		{"loose files", ""},
+		{"archive", archiveGzip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+			t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+			t.Setenv("XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+			t.Setenv(passphraseEnv, "secret")
+
+			files := map[string]string{
+				".bashrc":                "alias ll='ls -la'\n",
+				".config/nvim/init.lua":  "vim.o.number = true\n",
+				".config/nvim/lua/x.lua": "return {}\n",
+				".config/foo/foo":        "a directory holding a file of its name\n",
+				"notes/todo.txt":         "encrypted\n",
+			}
+			writeFiles(t, home, files)
+			if err := os.Chmod(filepath.Join(home, ".bashrc"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			writeFiles(t, home, map[string]string{".config/vy/backup.toml": `
+default_profile = "test"
+
+[[profiles.test.entries]]
+name = "bash"
+path = "~/.bashrc"
+
+[[profiles.test.entries]]
+name = "nvim"
+path = "~/.config/nvim"
+
+[[profiles.test.entries]]
+name = "foo"
+path = "~/.config/foo"
+
+[[profiles.test.entries]]
+name = "notes"
+path = "~/notes"
+encrypt = true
+`})
+
+			drive := fileScheme + t.TempDir()
+			opts := BackupOptions{Drives: []string{drive}, Jobs: 2, Retries: -1, Archive: tt.archive}
+			if code := HandleBackup(opts); code != ExitSuccess {
+				t.Fatalf("first backup exited with %d", code)
+			}
+			// Unchanged entries point at the first snapshot
+			if code := HandleBackup(opts); code != ExitSuccess {
+				t.Fatalf("second backup exited with %d", code)
+			}
+
+			writeFiles(t, home, map[string]string{".bashrc": "changed\n"})
+			for _, path := range []string{".config/nvim", ".config/foo", "notes"} {
+				if err := os.RemoveAll(filepath.Join(home, filepath.FromSlash(path))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if code := HandleRestore(RestoreOptions{Drive: drive, Mode: RestoreOverwrite}); code != ExitSuccess {
+				t.Fatalf("restore exited with %d", code)
+			}
+			for name, want := range files {
+				data, err := os.ReadFile(filepath.Join(home, filepath.FromSlash(name)))
+				if err != nil || string(data) != want {
+					t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+				}
+			}
+			info, err := os.Stat(filepath.Join(home, ".bashrc"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != 0600 {
+				t.Errorf(".bashrc mode = %v, want 0600", mode)
+			}
+		})
+	}
+}
@@ -0,0 +1,419 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"filippo.io/age"
+	"golang.org/x/term"
+)
+
+// Passphrase used for encrypted entries when no recipients are configured
+const passphraseEnv = "VY_BACKUP_PASSPHRASE"
+
+// Suffix of the single object an encrypted entry is uploaded as
+const encryptedSuffix = ".tar.gz.age"
+
+const (
+	encryptPassphrase = "passphrase"
+	encryptX25519     = "x25519"
+)
+
+// The [encryption] table of backup.toml
+//
+//	[encryption]
+//	recipients = ["age1..."]            # encrypt to these X25519 keys instead of a passphrase
+//	identity_file = "~/.config/vy/age.key" # private key used by restore
+type EncryptionConfig struct {
+	Recipients   []string `toml:"recipients"`
+	IdentityFile string   `toml:"identity_file"`
+}
+
+// How an entry was encrypted, so restore can pick the right identity
+type ManifestEncryption struct {
+	Scheme     string   `json:"scheme"` // always "age"
+	Method     string   `json:"method"` // encryptPassphrase or encryptX25519
+	Recipients []string `json:"recipients,omitempty"`
+	Object     string   `json:"object"` // name of the encrypted archive inside the entry directory
+	SHA256     string   `json:"sha256,omitempty"`
+}
+
+// Identifies the keys entries are encrypted to, it changes when recipients are rotated or
+// passphrase and keys are swapped. A passphrase can't be told apart from another one.
+func keysFingerprint(method string, recipients []string) string {
+	if method != encryptX25519 {
+		return method
+	}
+	sorted := append([]string(nil), recipients...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return method + ":" + hex.EncodeToString(sum[:8])
+}
+
+// keysFingerprint of the keys target is encrypted to with this config, empty when it is not encrypted
+func (c EncryptionConfig) keysFor(target BackupEntry) string {
+	switch {
+	case !target.encrypted():
+		return ""
+	case len(c.Recipients) > 0:
+		return keysFingerprint(encryptX25519, c.Recipients)
+	default:
+		return keysFingerprint(encryptPassphrase, nil)
+	}
+}
+
+// Default location of the private key written by vy backup keygen
+func defaultIdentityFile(homeDir string) string {
+	return filepath.Join(filepath.Dir(backupConfigPath(homeDir)), "age.key")
+}
+
+// Encrypts entries for one backup run, asking for the passphrase at most once
+// even when several workers need it at the same time
+type encryptor struct {
+	config     EncryptionConfig
+	recipients []age.Recipient
+	method     string
+	err        error
+	once       sync.Once
+}
+
+func (e *encryptor) setup() error {
+	e.once.Do(func() {
+		e.err = e.load()
+	})
+	return e.err
+}
+
+func (e *encryptor) load() error {
+	if len(e.config.Recipients) > 0 {
+		for _, key := range e.config.Recipients {
+			r, err := age.ParseX25519Recipient(key)
+			if err != nil {
+				return fmt.Errorf("invalid recipient %s: %w", key, err)
+			}
+			e.recipients = append(e.recipients, r)
+		}
+		e.method = encryptX25519
+		return nil
+	}
+
+	passphrase, err := readPassphrase("Passphrase for encrypted entries: ", true)
+	if err != nil {
+		return err
+	}
+	r, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return err
+	}
+	e.recipients = []age.Recipient{r}
+	e.method = encryptPassphrase
+	return nil
+}
+
+// Pack the entry's files into <dir>/<remote>.tar.gz.age and describe it for the manifest
+func (e *encryptor) encryptEntry(target BackupEntry, files []ManifestFile, dir string) (string, *ManifestEncryption, error) {
+	if err := e.setup(); err != nil {
+		return "", nil, err
+	}
+
+	object := target.Remote + encryptedSuffix
+	path := filepath.Join(dir, object)
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", nil, err
+	}
+	defer out.Close()
+
+	w, err := age.Encrypt(out, e.recipients...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := writeTarGz(w, entryRoot(target.Path), files); err != nil {
+		return "", nil, err
+	}
+	if err := w.Close(); err != nil {
+		return "", nil, err
+	}
+	if err := out.Close(); err != nil {
+		return "", nil, err
+	}
+
+	// The contents can't be checked without the key, so verify checks the archive itself
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, &ManifestEncryption{
+		Scheme:     "age",
+		Method:     e.method,
+		Recipients: e.config.Recipients,
+		Object:     object,
+		SHA256:     sum,
+	}, nil
+}
+
+// Write the listed files, relative to root, as a gzipped tarball
+func writeTarGz(w io.Writer, root string, files []ManifestFile) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, file := range files {
+		if err := addTarFile(tw, filepath.Join(root, filepath.FromSlash(file.Path)), file.Path); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addTarFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Unpack a tarball into dir, refusing paths that would escape it
+func extractTarGz(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	return extractTar(gz, dir)
+}
+
+// Unpack an uncompressed tar stream into dir, refusing paths that would escape it
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in archive: %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Decrypts entries during restore, loading each kind of identity at most once
+type decryptor struct {
+	config     EncryptionConfig
+	homeDir    string
+	identities map[string][]age.Identity
+}
+
+func (d *decryptor) identitiesFor(method string) ([]age.Identity, error) {
+	if ids, ok := d.identities[method]; ok {
+		return ids, nil
+	}
+
+	var ids []age.Identity
+	switch method {
+	case encryptPassphrase:
+		passphrase, err := readPassphrase("Passphrase for encrypted entries: ", false)
+		if err != nil {
+			return nil, err
+		}
+		id, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		ids = []age.Identity{id}
+	case encryptX25519:
+		path := d.config.IdentityFile
+		if path == "" {
+			path = defaultIdentityFile(d.homeDir)
+		}
+		f, err := os.Open(expandHome(path, d.homeDir))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read identity file: %w", err)
+		}
+		defer f.Close()
+		if ids, err = age.ParseIdentities(f); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown encryption method: %s", method)
+	}
+
+	if d.identities == nil {
+		d.identities = make(map[string][]age.Identity)
+	}
+	d.identities[method] = ids
+	return ids, nil
+}
+
+// Decrypt an entry's archive and unpack its files into dir
+func (d *decryptor) decryptEntry(enc *ManifestEncryption, object, dir string) error {
+	ids, err := d.identitiesFor(enc.Method)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(object)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := age.Decrypt(f, ids...)
+	if err != nil {
+		return err
+	}
+	return extractTarGz(r, dir)
+}
+
+// Read the passphrase from the environment, or from the terminal without echo
+func readPassphrase(prompt string, confirm bool) (string, error) {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("no terminal to ask for a passphrase, set %s", passphraseEnv)
+	}
+	defer tty.Close()
+
+	ask := func(prompt string) (string, error) {
+		fmt.Fprint(tty, prompt)
+		defer fmt.Fprintln(tty)
+
+		// ReadPassword puts echo back when it returns, Ctrl-C must too
+		fd := int(tty.Fd())
+		state, err := term.GetState(fd)
+		if err != nil {
+			return "", err
+		}
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(interrupt)
+		read := make(chan struct{})
+		defer close(read)
+		go func() {
+			select {
+			case <-interrupt:
+				term.Restore(fd, state)
+				fmt.Fprintln(tty)
+				os.Exit(130)
+			case <-read:
+			}
+		}()
+
+		line, err := term.ReadPassword(fd)
+		return string(line), err
+	}
+
+	passphrase, err := ask(prompt)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("empty passphrase")
+	}
+	if confirm {
+		again, err := ask("Confirm passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// Create an X25519 key pair for encrypted backups and print the recipient to put in backup.toml
+func HandleKeygen() {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Printf("Error getting home directory: %v\n", err)
+		return
+	}
+
+	path := defaultIdentityFile(homeDir)
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("%s already exists, not overwriting it\n", path)
+		return
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		fmt.Printf("Error generating key: %v\n", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fmt.Printf("Error creating %s: %v\n", filepath.Dir(path), err)
+		return
+	}
+	content := fmt.Sprintf("# public key: %s\n%s\n", identity.Recipient(), identity)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		fmt.Printf("Error writing key: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Private key written to %s, keep a copy somewhere other than your backups!\n", path)
+	fmt.Printf("Add this to %s:\n\n[encryption]\nrecipients = [\"%s\"]\n", backupConfigPath(homeDir), identity.Recipient())
+}
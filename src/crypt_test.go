@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := t.TempDir()
+	writeFiles(t, keys, map[string]string{
+		"age.key":   identity.String() + "\n",
+		"other.key": other.String() + "\n",
+	})
+
+	tests := []struct {
+		name       string
+		passphrase string // for decrypting, VY_BACKUP_PASSPHRASE is "secret" while encrypting
+		encrypt    EncryptionConfig
+		decrypt    EncryptionConfig
+		method     string
+		ok         bool
+	}{
+		{"passphrase", "secret", EncryptionConfig{}, EncryptionConfig{}, encryptPassphrase, true},
+		{"wrong passphrase", "guess", EncryptionConfig{}, EncryptionConfig{}, encryptPassphrase, false},
+		{
+			"x25519",
+			"",
+			EncryptionConfig{Recipients: []string{identity.Recipient().String()}},
+			EncryptionConfig{IdentityFile: filepath.Join(keys, "age.key")},
+			encryptX25519,
+			true,
+		},
+		{
+			"x25519 with another key",
+			"",
+			EncryptionConfig{Recipients: []string{identity.Recipient().String()}},
+			EncryptionConfig{IdentityFile: filepath.Join(keys, "other.key")},
+			encryptX25519,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := t.TempDir()
+			files := map[string]string{"id_ed25519": "private\n", "sub/config": "Host *\n"}
+			writeFiles(t, src, files)
+			target := BackupEntry{Name: "ssh", Remote: "ssh", Path: src}
+			entry, err := buildManifestEntry(target, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Setenv(passphraseEnv, "secret")
+			e := &encryptor{config: tt.encrypt}
+			object, enc, err := e.encryptEntry(target, entry.Files, t.TempDir())
+			if err != nil {
+				t.Fatalf("encryptEntry: %v", err)
+			}
+			if sum, _ := hashFile(object); enc.Method != tt.method || enc.Object != "ssh"+encryptedSuffix || enc.SHA256 != sum {
+				t.Errorf("encryption = %+v, want method %s and the object's hash %s", enc, tt.method, sum)
+			}
+
+			t.Setenv(passphraseEnv, tt.passphrase)
+			d := &decryptor{config: tt.decrypt, homeDir: t.TempDir()}
+			dir := t.TempDir()
+			err = d.decryptEntry(enc, object, dir)
+			if (err == nil) != tt.ok {
+				t.Fatalf("decryptEntry error = %v, want ok %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			for name, content := range files {
+				data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if err != nil || string(data) != content {
+					t.Errorf("%s = %q, %v, want %q", name, data, err, content)
+				}
+			}
+		})
+	}
+}
+
+func TestKeysFingerprint(t *testing.T) {
+	a, b := "age1aaa", "age1bbb"
+	encrypt := true
+	encrypted := BackupEntry{Encrypt: &encrypt}
+
+	tests := []struct {
+		name   string
+		config EncryptionConfig
+		target BackupEntry
+		want   string
+	}{
+		{"not encrypted", EncryptionConfig{Recipients: []string{a}}, BackupEntry{}, ""},
+		{"passphrase", EncryptionConfig{}, encrypted, encryptPassphrase},
+		{"recipients", EncryptionConfig{Recipients: []string{a, b}}, encrypted, keysFingerprint(encryptX25519, []string{a, b})},
+		{"recipients in another order", EncryptionConfig{Recipients: []string{b, a}}, encrypted, keysFingerprint(encryptX25519, []string{a, b})},
+	}
+	for _, tt := range tests {
+		if got := tt.config.keysFor(tt.target); got != tt.want {
+			t.Errorf("%s: keysFor = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if keysFingerprint(encryptX25519, []string{a}) == keysFingerprint(encryptX25519, []string{a, b}) {
+		t.Errorf("adding a recipient kept the fingerprint")
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File the dconf settings of an entry are dumped to
+const dconfFile = "dconf.ini"
+
+// Where generated entries are written before they are backed up, honouring XDG_CACHE_HOME
+func dumpDir(homeDir, remote string) string {
+	return filepath.Join(filepath.Dir(backupStatePath(homeDir)), "dumps", remote)
+}
+
+// Where restore in backup mode saves the settings it replaces, by entry. Not under the dumps,
+// which are backed up, and kept like other user data, honouring XDG_DATA_HOME.
+func restoreBackupDir(homeDir, stamp, remote string) string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		dir = filepath.Join(homeDir, ".local", "share")
+	}
+	return filepath.Join(dir, "vy", "restore-backups", stamp, remote)
+}
+
+// Save settings about to be replaced by a restore to dir/name
+func saveRestoreBackup(dir, name string, data []byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), data, 0600)
+}
+
+// Write a dump of a generated entry to dir/name
+func writeDump(dir, name string, data []byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// Keep the file untouched when nothing changed, so it is not hashed and uploaded again
+	file := filepath.Join(dir, name)
+	if current, err := os.ReadFile(file); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	return os.WriteFile(file, data, 0600)
+}
+
+// Dump the given dconf paths into dir/dconf.ini. Sections are made absolute so the
+// file can be loaded back with dconf load / whatever paths it was made from.
+func dumpDconf(paths []string, dir string) error {
+	var ini bytes.Buffer
+	for _, path := range paths {
+		output, err := dconfCommand(nil, "dump", path)
+		if err != nil {
+			return err
+		}
+		ini.WriteString(absoluteSections(path, output))
+	}
+
+	return writeDump(dir, dconfFile, ini.Bytes())
+}
+
+// Rewrite the section names of a dump of path, e.g. [interface] in a dump of
+// /org/gnome/desktop/ becomes [org/gnome/desktop/interface]
+func absoluteSections(path string, dump []byte) string {
+	base := strings.Trim(path, "/")
+	lines := strings.Split(string(dump), "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") || base == "" {
+			continue
+		}
+		section := line[1 : len(line)-1]
+		if section == "/" {
+			lines[i] = "[" + base + "]"
+		} else {
+			lines[i] = "[" + base + "/" + section + "]"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Put dumped settings back with dconf load, saving the current ones to backupDir first in backup mode
+func restoreDconf(file, backupDir, mode string) (string, error) {
+	if mode == RestoreSkip {
+		return "skip", nil
+	}
+
+	ini, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	if mode == RestoreBackup {
+		current, err := dconfCommand(nil, "dump", "/")
+		if err != nil {
+			return "", err
+		}
+		if err := saveRestoreBackup(backupDir, dconfFile, current); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := dconfCommand(ini, "load", "/"); err != nil {
+		return "", err
+	}
+	return "load", nil
+}
+
+func dconfCommand(stdin []byte, args ...string) ([]byte, error) {
+	return exportCommand(stdin, "dconf", args...)
+}
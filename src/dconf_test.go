@@ -0,0 +1,44 @@
+package cmd
+
+import "testing"
+
+func TestAbsoluteSections(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		dump string
+		want string
+	}{
+		{
+			name: "whole database",
+			path: "/",
+			dump: "[org/gnome/desktop/interface]\ncolor-scheme='prefer-dark'\n",
+			want: "[org/gnome/desktop/interface]\ncolor-scheme='prefer-dark'\n",
+		},
+		{
+			name: "sub path",
+			path: "/org/gnome/desktop/",
+			dump: "[interface]\ncolor-scheme='prefer-dark'\n\n[wm/preferences]\nbutton-layout='close'\n",
+			want: "[org/gnome/desktop/interface]\ncolor-scheme='prefer-dark'\n\n[org/gnome/desktop/wm/preferences]\nbutton-layout='close'\n",
+		},
+		{
+			name: "keys of the path itself",
+			path: "/org/gnome/terminal/",
+			dump: "[/]\ndefault='a'\n",
+			want: "[org/gnome/terminal]\ndefault='a'\n",
+		},
+		{
+			name: "values that look like sections",
+			path: "/org/gnome/shell/",
+			dump: "[extensions]\nlist=['a', 'b']\nname='[x]'\n",
+			want: "[org/gnome/shell/extensions]\nlist=['a', 'b']\nname='[x]'\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := absoluteSections(tt.path, []byte(tt.dump)); got != tt.want {
+				t.Errorf("absoluteSections(%q) =\n%s\nwant\n%s", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Drive used when neither -d nor the config name one
+const defaultDrive = "gdrive:"
+
+// Drives to back up to: the -d flags, else destinations from the config, else gdrive
+func backupDestinations(drives []string, config *BackupConfig) []string {
+	if len(drives) > 0 {
+		return uniqueDrives(drives)
+	}
+	for _, drive := range config.Destinations {
+		drives = append(drives, NormalizeDrive(drive))
+	}
+	if len(drives) == 0 {
+		return []string{defaultDrive}
+	}
+	return uniqueDrives(drives)
+}
+
+func uniqueDrives(drives []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, drive := range drives {
+		if !seen[drive] {
+			seen[drive] = true
+			unique = append(unique, drive)
+		}
+	}
+	return unique
+}
+
+// Local work of a run, shared by its destinations so files are hashed,
+// encrypted and archived once however many drives they go to
+type backupLocal struct {
+	started  *Manifest // every destination gets the same snapshot name and creation time
+	stageDir string
+	transfer *transfer // retries, bandwidth limit and timeout of every remote operation
+	host     string    // name of this machine on the remote
+	out      io.Writer // what is printed along the way, stderr with --json
+	hooks    *hookRun
+	encrypt  *encryptor
+	dumped   bool
+
+	mu        sync.Mutex
+	hashed    map[string]ManifestEntry // by remote name
+	encrypted map[string]encryptedObject
+
+	archive       *archiveWriter
+	archived      []entryResult // results of the entries in archive, in profile order
+	archiveSHA256 string        // set once the archive is finished
+
+	secretsPolicy string
+	secretsAllow  map[string]bool
+	secrets       map[string][]secretFinding // by remote name, nil until scanned
+	redacted      map[string]redactedCopy
+}
+
+type redactedCopy struct {
+	target BackupEntry
+	entry  ManifestEntry
+}
+
+type encryptedObject struct {
+	path       string
+	encryption *ManifestEncryption
+}
+
+func newBackupLocal(transfer *transfer, host string) (*backupLocal, error) {
+	stageDir, err := os.MkdirTemp("", "vy-backup-*")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating staging directory: %w", err)
+	}
+	return &backupLocal{
+		started:   newManifest(),
+		stageDir:  stageDir,
+		transfer:  transfer,
+		host:      host,
+		hashed:    make(map[string]ManifestEntry),
+		encrypted: make(map[string]encryptedObject),
+		redacted:  make(map[string]redactedCopy),
+	}, nil
+}
+
+// A fresh manifest for a destination
+func (l *backupLocal) manifest() *Manifest {
+	manifest := *l.started
+	return &manifest
+}
+
+func (l *backupLocal) Close() error {
+	return os.RemoveAll(l.stageDir)
+}
+
+// dconf settings and package lists are dumped once per run
+func (l *backupLocal) dump(entries []BackupEntry, verbose bool) {
+	if !l.dumped {
+		dumpGenerated(l.out, entries, verbose)
+		l.dumped = true
+	}
+}
+
+// The manifest entry of target, hashed by the first destination that needs it
+func (l *backupLocal) hash(target BackupEntry, previous *ManifestEntry) (ManifestEntry, error) {
+	l.mu.Lock()
+	entry, ok := l.hashed[target.Remote]
+	l.mu.Unlock()
+	if ok {
+		return entry, nil
+	}
+
+	entry, err := buildManifestEntry(target, previous)
+	if err != nil {
+		return entry, err
+	}
+	l.mu.Lock()
+	l.hashed[target.Remote] = entry
+	l.mu.Unlock()
+	return entry, nil
+}
+
+// Encrypt target once, later destinations upload the same object
+func (l *backupLocal) encryptEntry(target BackupEntry, files []ManifestFile) (string, *ManifestEncryption, error) {
+	l.mu.Lock()
+	object, ok := l.encrypted[target.Remote]
+	l.mu.Unlock()
+	if ok {
+		return object.path, object.encryption, nil
+	}
+
+	path, encryption, err := l.encrypt.encryptEntry(target, files, l.stageDir)
+	if err != nil {
+		return "", nil, err
+	}
+	l.mu.Lock()
+	l.encrypted[target.Remote] = encryptedObject{path: path, encryption: encryption}
+	l.mu.Unlock()
+	return path, encryption, nil
+}
+
+// Start the archive of a run, only the first destination fills it
+func (l *backupLocal) startArchive(format string) error {
+	if l.archive != nil {
+		return nil
+	}
+	archive, err := newArchiveWriter(filepath.Join(l.stageDir, archiveName(format)), format)
+	if err != nil {
+		return err
+	}
+	l.archive = archive
+	return nil
+}
+
+// Finish the archive with the manifest inside, once
+func (l *backupLocal) finishArchive(manifest *Manifest) error {
+	if l.archiveSHA256 == "" {
+		if err := l.archive.addManifest(manifest); err != nil {
+			return err
+		}
+		if err := l.archive.Close(); err != nil {
+			return err
+		}
+		// Only the manifest beside the archive has its hash, for vy backup verify
+		sum, err := hashFile(l.archive.path)
+		if err != nil {
+			return err
+		}
+		l.archiveSHA256 = sum
+	}
+	manifest.ArchiveSHA256 = l.archiveSHA256
+	return nil
+}
+
+// Exit code of a run over several destinations
+func combinedExitCode(reports []*BackupReport) int {
+	counts := make(map[int]int)
+	for _, report := range reports {
+		counts[report.ExitCode]++
+	}
+	switch {
+	case counts[ExitSuccess] == len(reports):
+		return ExitSuccess
+	case counts[ExitNoRemote] == len(reports):
+		return ExitNoRemote
+	case counts[ExitSuccess] == 0 && counts[ExitPartial] == 0:
+		return ExitFailed
+	}
+	return ExitPartial
+}
+
+func printDestinations(out io.Writer, reports []*BackupReport) {
+	fmt.Fprintf(out, "\nBacked up to %d destinations:\n", len(reports))
+	for _, report := range reports {
+		icon := "✅"
+		if report.ExitCode != ExitSuccess {
+			icon = "❌"
+		}
+		uploaded := 0
+		for _, entry := range report.Entries {
+			if entry.Status == entryUploaded || entry.Status == entryUnchanged {
+				uploaded++
+			}
+		}
+		fmt.Fprintf(out, "  %s %-24s %s, %d of %d configurations\n", icon, report.Drive, report.Status, uploaded, len(report.Entries))
+		if report.Error != "" {
+			fmt.Fprintf(out, "      Error: %s\n", report.Error)
+		}
+	}
+}
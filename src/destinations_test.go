@@ -0,0 +1,56 @@
+package cmd
+
+import "testing"
+
+func TestCombinedExitCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes []int
+		want  int
+	}{
+		{"all succeeded", []int{ExitSuccess, ExitSuccess}, ExitSuccess},
+		{"one partial", []int{ExitSuccess, ExitPartial}, ExitPartial},
+		{"one failed", []int{ExitSuccess, ExitFailed}, ExitPartial},
+		{"one without a remote", []int{ExitNoRemote, ExitSuccess}, ExitPartial},
+		{"all without a remote", []int{ExitNoRemote, ExitNoRemote}, ExitNoRemote},
+		{"failed and without a remote", []int{ExitFailed, ExitNoRemote}, ExitFailed},
+		{"all failed", []int{ExitFailed, ExitFailed}, ExitFailed},
+		{"partial and failed", []int{ExitPartial, ExitFailed}, ExitPartial},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reports []*BackupReport
+			for _, code := range tt.codes {
+				report := newBackupReport("")
+				report.finish(code)
+				reports = append(reports, report)
+			}
+			if got := combinedExitCode(reports); got != tt.want {
+				t.Errorf("combinedExitCode(%v) = %d, want %d", tt.codes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupDestinations(t *testing.T) {
+	tests := []struct {
+		name   string
+		drives []string
+		config []string
+		want   []string
+	}{
+		{"default", nil, nil, []string{defaultDrive}},
+		{"from the config", nil, []string{"gdrive", "file:///mnt/usb"}, []string{"gdrive:", "file:///mnt/usb"}},
+		{"-d wins over the config", []string{"b2:"}, []string{"gdrive"}, []string{"b2:"}},
+		{"repeated", []string{"b2:", "gdrive:", "b2:"}, nil, []string{"b2:", "gdrive:"}},
+		{"repeated in the config", nil, []string{"gdrive", "gdrive:"}, []string{"gdrive:"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := backupDestinations(tt.drives, &BackupConfig{Destinations: tt.config})
+			if !equalStrings(got, tt.want) {
+				t.Errorf("backupDestinations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+)
+
+// Files larger than this are only listed, not diffed
+const maxTextDiffSize = 64 * 1024
+
+type DiffOptions struct {
+	Drive    string
+	Verbose  bool
+	Snapshot string   // snapshot to compare with, empty means the latest one
+	Profile  string   // profile the entries come from, same as for backup
+	Entries  []string // entries to compare, empty means all of them
+}
+
+// Files of an entry that differ between a snapshot and the local copy
+type entryChanges struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+}
+
+func (c entryChanges) empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Modified) == 0
+}
+
+// Compare the files of an entry in a manifest with the ones found locally
+func compareEntry(previous *ManifestEntry, current ManifestEntry) entryChanges {
+	var changes entryChanges
+	seen := make(map[string]bool)
+	for _, file := range current.Files {
+		seen[file.Path] = true
+		var old *ManifestFile
+		if previous != nil {
+			old = previous.File(file.Path)
+		}
+		switch {
+		case old == nil:
+			changes.Added = append(changes.Added, file.Path)
+		case old.SHA256 != file.SHA256:
+			changes.Modified = append(changes.Modified, file.Path)
+		}
+	}
+	if previous != nil {
+		for _, file := range previous.Files {
+			if !seen[file.Path] {
+				changes.Removed = append(changes.Removed, file.Path)
+			}
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Modified)
+	return changes
+}
+
+// Show which entries changed locally since a snapshot, before uploading a new one
+func HandleDiff(opts DiffOptions) {
+	store, err := newStorage(opts.Drive)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	config, entries, err := loadProfile(opts.Profile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	targets, err := selectEntries(entries, opts.Entries)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	host, err := currentHost(config.Host)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	snapshotDir, err := resolveSnapshotDir(store, host, opts.Snapshot, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	manifest, err := fetchManifest(store, snapshotDir, manifestName)
+	if err != nil {
+		fmt.Printf("❌ No manifest found in %s on %s, nothing to compare with\n  Error: %v\n", snapshotDir, opts.Drive, err)
+		return
+	}
+
+	// Compare fresh dumps of dconf and packages, the way the next backup would upload them
+	dumpGenerated(os.Stdout, targets, opts.Verbose)
+
+	fmt.Printf("Changes since snapshot %s on %s\n\n", manifest.Snapshot, opts.Drive)
+
+	changed := 0
+	for _, target := range targets {
+		previous := manifest.Entry(target.Remote)
+		if previous != nil && previous.Error != "" {
+			previous = nil
+		}
+
+		var current ManifestEntry
+		if _, err := os.Stat(target.Path); err == nil {
+			if current, err = buildManifestEntry(target, previous); err != nil {
+				fmt.Printf("❌ %s: %v\n\n", target.Name, err)
+				continue
+			}
+		} else if previous == nil {
+			// Neither here nor in the snapshot
+			continue
+		}
+
+		changes := compareEntry(previous, current)
+		if changes.empty() {
+			if opts.Verbose {
+				fmt.Printf("✅ %s: unchanged\n", target.Name)
+			}
+			continue
+		}
+		changed++
+
+		switch {
+		case previous == nil:
+			fmt.Printf("➕ %s: new, %d files\n", target.Name, len(changes.Added))
+		case len(current.Files) == 0:
+			fmt.Printf("➖ %s: gone, %d files\n", target.Name, len(changes.Removed))
+		default:
+			fmt.Printf("📝 %s: %d added, %d removed, %d modified\n", target.Name, len(changes.Added), len(changes.Removed), len(changes.Modified))
+		}
+		for _, path := range changes.Added {
+			fmt.Printf("    added      %s\n", path)
+		}
+		for _, path := range changes.Removed {
+			fmt.Printf("    removed    %s\n", path)
+		}
+		for _, path := range changes.Modified {
+			fmt.Printf("    modified   %s\n", path)
+		}
+
+		// Encrypted and archived entries can't be read one file at a time
+		if previous == nil || previous.Encryption != nil || manifest.Archive != "" {
+			fmt.Println()
+			continue
+		}
+		remoteDir := snapshotDir + "/" + target.Remote
+		if previous.StoredIn != "" {
+			remoteDir = storedInDir(snapshotDir, previous.StoredIn) + "/" + target.Remote
+		}
+		for _, path := range changes.Modified {
+			local := filepath.Join(entryRoot(target.Path), filepath.FromSlash(path))
+			diff, err := textDiff(store, remoteDir, target.Remote, path, previous.File(path), local)
+			if err != nil {
+				fmt.Printf("    ⚠️  Could not diff %s: %v\n", path, err)
+				continue
+			}
+			fmt.Print(diff)
+		}
+		fmt.Println()
+	}
+
+	if changed == 0 {
+		fmt.Println("Nothing changed since the last backup")
+		return
+	}
+	fmt.Printf("%d configurations changed since the last backup\n", changed)
+}
+
+// Unified diff of a small text file against its copy on the drive, empty for anything else
+func textDiff(store Storage, remoteDir, remote, path string, old *ManifestFile, local string) (string, error) {
+	if old.Size > maxTextDiffSize || !isSmallText(local) {
+		return "", nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "vy-diff-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := store.Get(remoteDir+"/"+path, tmpDir); err != nil {
+		return "", err
+	}
+	remoteCopy := filepath.Join(tmpDir, filepath.Base(path))
+	if !isSmallText(remoteCopy) {
+		return "", nil
+	}
+
+	label := remote + "/" + path
+	cmd := exec.Command("diff", "-u", "--label", "a/"+label, "--label", "b/"+label, remoteCopy, local)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// diff exits with 1 when the files differ
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return "", fmt.Errorf("diff error: %w\nError: %s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// Small enough to show and valid UTF-8 without NUL bytes
+func isSmallText(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > maxTextDiffSize {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	return err == nil && utf8.Valid(data) && !bytes.Contains(data, []byte{0})
+}
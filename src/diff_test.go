@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareEntry(t *testing.T) {
+	files := func(pathsAndHashes ...string) []ManifestFile {
+		var list []ManifestFile
+		for i := 0; i < len(pathsAndHashes); i += 2 {
+			list = append(list, ManifestFile{Path: pathsAndHashes[i], SHA256: pathsAndHashes[i+1]})
+		}
+		return list
+	}
+
+	tests := []struct {
+		name     string
+		previous *ManifestEntry
+		current  ManifestEntry
+		want     entryChanges
+	}{
+		{"unchanged", &ManifestEntry{Files: files("a", "1", "b", "2")}, ManifestEntry{Files: files("b", "2", "a", "1")}, entryChanges{}},
+		{"not in the snapshot", nil, ManifestEntry{Files: files("b", "2", "a", "1")}, entryChanges{Added: []string{"a", "b"}}},
+		{"removed locally", &ManifestEntry{Files: files("a", "1", "b", "2")}, ManifestEntry{}, entryChanges{Removed: []string{"a", "b"}}},
+		{
+			"everything",
+			&ManifestEntry{Files: files("kept", "1", "edited", "2", "deleted", "3")},
+			ManifestEntry{Files: files("kept", "1", "edited", "x", "new", "4")},
+			entryChanges{Added: []string{"new"}, Removed: []string{"deleted"}, Modified: []string{"edited"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareEntry(tt.previous, tt.current)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compareEntry = %+v, want %+v", got, tt.want)
+			}
+			if got.empty() != reflect.DeepEqual(tt.want, entryChanges{}) {
+				t.Errorf("empty() = %v for %+v", got.empty(), got)
+			}
+		})
+	}
+}
+
+func TestTextDiff(t *testing.T) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		t.Skip("diff is not installed")
+	}
+	store := newTestStorage(t)
+	remote := t.TempDir()
+	writeFiles(t, remote, map[string]string{"bash/.bashrc": "alias ll='ls -la'\n", "bin/tool": "\x00\x01"})
+	if err := store.Put(filepath.Join(remote, "bash"), "snap/bash", Filter{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(filepath.Join(remote, "bin"), "snap/bin", Filter{}); err != nil {
+		t.Fatal(err)
+	}
+	local := t.TempDir()
+	writeFiles(t, local, map[string]string{".bashrc": "alias ll='ls -lah'\n", "tool": "\x00\x02"})
+
+	diff, err := textDiff(store, "snap/bash", "bash", ".bashrc", &ManifestFile{Size: 18}, filepath.Join(local, ".bashrc"))
+	if err != nil || !strings.Contains(diff, "-alias ll='ls -la'") || !strings.Contains(diff, "+alias ll='ls -lah'") {
+		t.Errorf("textDiff = %q, %v", diff, err)
+	}
+	if diff, err := textDiff(store, "snap/bin", "bin", "tool", &ManifestFile{Size: 2}, filepath.Join(local, "tool")); err != nil || diff != "" {
+		t.Errorf("binary file: textDiff = %q, %v, want nothing", diff, err)
+	}
+}
@@ -0,0 +1,376 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Settings that don't live in a file, such as the installed VS Code extensions. An exporter
+// writes them to a file before every backup, which is then backed up like any other entry,
+// and applies that file again on restore.
+type Exporter interface {
+	// Name used in profiles, e.g. export = "crontab"
+	Name() string
+	// File the export is written to in the entry directory
+	File() string
+	// Current settings of this machine, as the file's contents
+	Export() ([]byte, error)
+	// What Apply would change on this machine, for vy restore --dry-run
+	Pending(data []byte) ([]ExportChange, error)
+	// Put exported settings back, returning what was done, e.g. "install"
+	Apply(data []byte) (string, error)
+}
+
+type ExportChange struct {
+	Action string // e.g. install, enable, replace
+	Item   string
+}
+
+// Exporters that ship with vy, each one is also an optional entry of the built-in profile
+var exporters = []Exporter{
+	vscodeExtensions{},
+	gnomeExtensions{},
+	userCrontab{},
+}
+
+func findExporter(name string) (Exporter, error) {
+	for _, e := range exporters {
+		if e.Name() == name {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown exporter: %s (use %s)", name, strings.Join(exporterNames(), ", "))
+}
+
+// File an exporter writes, or the name itself for an unknown one so callers get a clear error later
+func exportFile(name string) string {
+	if exporter, err := findExporter(name); err == nil {
+		return exporter.File()
+	}
+	return name
+}
+
+func exporterNames() []string {
+	names := make([]string, 0, len(exporters))
+	for _, e := range exporters {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// Write what the named exporter gives into dir
+func dumpExport(name, dir string) error {
+	exporter, err := findExporter(name)
+	if err != nil {
+		return err
+	}
+	data, err := exporter.Export()
+	if err != nil {
+		return err
+	}
+	return writeDump(dir, exporter.File(), data)
+}
+
+// Apply an exported file, saving the current settings to backupDir first in backup mode
+func restoreExport(name, file, backupDir, mode string) (string, error) {
+	if mode == RestoreSkip {
+		return "skip", nil
+	}
+	exporter, err := findExporter(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	if mode == RestoreBackup {
+		current, err := exporter.Export()
+		if err != nil {
+			return "", err
+		}
+		if err := saveRestoreBackup(backupDir, exporter.File(), current); err != nil {
+			return "", err
+		}
+	}
+	return exporter.Apply(data)
+}
+
+// Print what restoreExport would change
+func printPendingExport(name, file, mode string) {
+	if mode == RestoreSkip {
+		fmt.Printf("    %-10s %s\n", "skip", filepath.Base(file))
+		return
+	}
+	exporter, err := findExporter(name)
+	if err != nil {
+		fmt.Printf("    ⚠️  %v\n", err)
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Printf("    ⚠️  %v\n", err)
+		return
+	}
+	changes, err := exporter.Pending(data)
+	if err != nil {
+		fmt.Printf("    ⚠️  %v\n", err)
+		return
+	}
+	if len(changes) == 0 {
+		fmt.Printf("    %-10s nothing to change\n", "unchanged")
+	}
+	for _, change := range changes {
+		fmt.Printf("    %-10s %s\n", change.Action, change.Item)
+	}
+}
+
+func exportCommand(stdin []byte, name string, args ...string) ([]byte, error) {
+	return exportCommandEnv(nil, stdin, name, args...)
+}
+
+// Like exportCommand with extra environment variables, e.g. LC_ALL=C where an error message is matched
+func exportCommandEnv(env []string, stdin []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s error: %w\nError: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// Non-empty lines of command output, sorted so the export only changes with the settings
+func sortedLines(data []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// VS Code extensions from code --list-extensions, installed again with code --install-extension
+type vscodeExtensions struct{}
+
+func (vscodeExtensions) Name() string { return "vscode-extensions" }
+func (vscodeExtensions) File() string { return "extensions.txt" }
+
+func (vscodeExtensions) Export() ([]byte, error) {
+	output, err := exportCommand(nil, "code", "--list-extensions")
+	if err != nil {
+		return nil, err
+	}
+	lines := sortedLines(output)
+	if len(lines) == 0 {
+		return []byte{}, nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// Recorded extensions that are not installed, ids are not case sensitive
+func (e vscodeExtensions) missing(data []byte) ([]string, error) {
+	output, err := exportCommand(nil, "code", "--list-extensions")
+	if err != nil {
+		return nil, err
+	}
+	installed := make(map[string]bool)
+	for _, id := range sortedLines(output) {
+		installed[strings.ToLower(id)] = true
+	}
+	var missing []string
+	for _, id := range sortedLines(data) {
+		if !installed[strings.ToLower(id)] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+func (e vscodeExtensions) Pending(data []byte) ([]ExportChange, error) {
+	missing, err := e.missing(data)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]ExportChange, 0, len(missing))
+	for _, id := range missing {
+		changes = append(changes, ExportChange{Action: "install", Item: id})
+	}
+	return changes, nil
+}
+
+func (e vscodeExtensions) Apply(data []byte) (string, error) {
+	missing, err := e.missing(data)
+	if err != nil {
+		return "", err
+	}
+	if len(missing) == 0 {
+		return "unchanged", nil
+	}
+	for _, id := range missing {
+		if _, err := exportCommand(nil, "code", "--install-extension", id); err != nil {
+			return "", err
+		}
+	}
+	return "install", nil
+}
+
+// GNOME Shell extensions and whether they are enabled, from gnome-extensions. Restore
+// enables and disables the installed ones, it can't install extensions by itself.
+type gnomeExtensions struct{}
+
+type gnomeExtension struct {
+	UUID    string `json:"uuid"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (gnomeExtensions) Name() string { return "gnome-extensions" }
+func (gnomeExtensions) File() string { return "extensions.json" }
+
+func (gnomeExtensions) current() ([]gnomeExtension, error) {
+	installed, err := exportCommand(nil, "gnome-extensions", "list")
+	if err != nil {
+		return nil, err
+	}
+	enabledOutput, err := exportCommand(nil, "gnome-extensions", "list", "--enabled")
+	if err != nil {
+		return nil, err
+	}
+	enabled := make(map[string]bool)
+	for _, uuid := range sortedLines(enabledOutput) {
+		enabled[uuid] = true
+	}
+
+	extensions := []gnomeExtension{}
+	for _, uuid := range sortedLines(installed) {
+		extensions = append(extensions, gnomeExtension{UUID: uuid, Enabled: enabled[uuid]})
+	}
+	return extensions, nil
+}
+
+func (e gnomeExtensions) Export() ([]byte, error) {
+	extensions, err := e.current()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(extensions, "", "  ")
+}
+
+func (e gnomeExtensions) Pending(data []byte) ([]ExportChange, error) {
+	var recorded []gnomeExtension
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return nil, fmt.Errorf("invalid GNOME extension list: %w", err)
+	}
+	extensions, err := e.current()
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]gnomeExtension)
+	for _, ext := range extensions {
+		current[ext.UUID] = ext
+	}
+
+	var changes []ExportChange
+	for _, ext := range recorded {
+		installed, ok := current[ext.UUID]
+		switch {
+		case !ok && ext.Enabled:
+			changes = append(changes, ExportChange{Action: "missing", Item: ext.UUID + " (install it from extensions.gnome.org)"})
+		case !ok || installed.Enabled == ext.Enabled:
+		case ext.Enabled:
+			changes = append(changes, ExportChange{Action: "enable", Item: ext.UUID})
+		default:
+			changes = append(changes, ExportChange{Action: "disable", Item: ext.UUID})
+		}
+	}
+	return changes, nil
+}
+
+func (e gnomeExtensions) Apply(data []byte) (string, error) {
+	changes, err := e.Pending(data)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	applied := 0
+	for _, change := range changes {
+		if change.Action == "missing" {
+			missing = append(missing, change.Item)
+			continue
+		}
+		if _, err := exportCommand(nil, "gnome-extensions", change.Action, change.Item); err != nil {
+			return "", err
+		}
+		applied++
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%d extensions are not installed: %s", len(missing), strings.Join(missing, ", "))
+	}
+	if applied == 0 {
+		return "unchanged", nil
+	}
+	return "enable", nil
+}
+
+// The user crontab from crontab -l, installed again with crontab -
+type userCrontab struct{}
+
+func (userCrontab) Name() string { return "crontab" }
+func (userCrontab) File() string { return "crontab" }
+
+func (userCrontab) Export() ([]byte, error) {
+	// crontab -l fails for a user without one, that is an empty crontab. The message is only
+	// known in English, so the locale is left out.
+	output, err := exportCommandEnv([]string{"LC_ALL=C"}, nil, "crontab", "-l")
+	if err != nil && strings.Contains(err.Error(), "no crontab for") {
+		return []byte{}, nil
+	}
+	return output, err
+}
+
+func (c userCrontab) Pending(data []byte) ([]ExportChange, error) {
+	// An empty export is left alone rather than wiping the crontab of this machine
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	current, err := c.Export()
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(current, data) {
+		return nil, nil
+	}
+	return []ExportChange{{Action: "replace", Item: fmt.Sprintf("crontab, %d lines", len(sortedLines(data)))}}, nil
+}
+
+func (c userCrontab) Apply(data []byte) (string, error) {
+	changes, err := c.Pending(data)
+	if err != nil {
+		return "", err
+	}
+	if len(changes) == 0 {
+		return "unchanged", nil
+	}
+	if _, err := exportCommand(data, "crontab", "-"); err != nil {
+		return "", err
+	}
+	return "replace", nil
+}
@@ -14,7 +14,7 @@ func CommitAndStage(message string) string {
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		return fmt.Sprintf("commit error: %w\nOutput: %s\nError: %s",
+		return fmt.Sprintf("commit error: %v\nOutput: %s\nError: %s",
 		err, stdout.String(), stderr.String())
 	}
 	
@@ -23,7 +23,7 @@ func CommitAndStage(message string) string {
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		return fmt.Sprintf("commit error: %w\nOutput: %s\nError: %s",
+		return fmt.Sprintf("commit error: %v\nOutput: %s\nError: %s",
 		err, stdout.String(), stderr.String())
 	}
 	return "all changes has been Staged and Committed :)"
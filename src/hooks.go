@@ -0,0 +1,335 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// When a hook runs
+const (
+	hookPre       = "pre"
+	hookPost      = "post"
+	hookOnFailure = "on_failure"
+)
+
+// What a failing pre hook does
+const (
+	hookAbort  = "abort"  // stop the whole run, the default for hooks of the run
+	hookSkip   = "skip"   // leave its entry out, the default for hooks of an entry
+	hookIgnore = "ignore" // carry on as if it succeeded
+)
+
+const (
+	defaultHookTimeout = 5 * time.Minute
+	maxHookOutput      = 16 << 10               // bytes of output kept in the report, the end of it
+	hookOutputGrace    = 100 * time.Millisecond // to read what is left in the pipe once the shell exits
+)
+
+type Hook struct {
+	Command string `toml:"command"`  // run with sh -c from the home directory
+	Timeout string `toml:"timeout"`  // e.g. "30s", 5 minutes by default
+	OnError string `toml:"on_error"` // pre hooks only: abort, skip (hooks of an entry) or ignore
+}
+
+// Commands run around a backup, in a profile for the whole run or in an entry for that entry
+//
+//	[[profiles.laptop.hooks.pre]]
+//	command = "systemctl --user stop syncthing"
+//
+//	[[profiles.laptop.hooks.post]]
+//	command = "systemctl --user start syncthing"
+type Hooks struct {
+	Pre       []Hook `toml:"pre"`        // before anything is read
+	Post      []Hook `toml:"post"`       // once every destination is done, whatever happened
+	OnFailure []Hook `toml:"on_failure"` // after post, when the run or entry did not fully succeed
+}
+
+// Output of a hook, part of the backup report
+type HookReport struct {
+	Hook     string  `json:"hook"`            // pre, post or on_failure
+	Entry    string  `json:"entry,omitempty"` // empty for hooks of the whole run
+	Command  string  `json:"command"`
+	Status   string  `json:"status"` // ok, failed or timeout
+	ExitCode int     `json:"exit_code"`
+	Duration float64 `json:"duration_seconds"`
+	Output   string  `json:"output,omitempty"` // stdout and stderr together
+	Error    string  `json:"error,omitempty"`
+}
+
+func (h Hooks) empty() bool {
+	return len(h.Pre) == 0 && len(h.Post) == 0 && len(h.OnFailure) == 0
+}
+
+func validateHooks(hooks Hooks, entry bool) error {
+	all := map[string][]Hook{hookPre: hooks.Pre, hookPost: hooks.Post, hookOnFailure: hooks.OnFailure}
+	for kind, list := range all {
+		for _, hook := range list {
+			if strings.TrimSpace(hook.Command) == "" {
+				return fmt.Errorf("%s hook without a command", kind)
+			}
+			if hook.Timeout != "" {
+				if timeout, err := time.ParseDuration(hook.Timeout); err != nil || timeout <= 0 {
+					return fmt.Errorf("%s hook %q has an invalid timeout: %s", kind, hook.Command, hook.Timeout)
+				}
+			}
+			switch {
+			case hook.OnError == "":
+			case kind != hookPre:
+				return fmt.Errorf("%s hook %q can't have on_error, only pre hooks can", kind, hook.Command)
+			case hook.OnError == hookAbort || hook.OnError == hookIgnore:
+			case hook.OnError == hookSkip && entry:
+			default:
+				return fmt.Errorf("pre hook %q has an invalid on_error: %s (use %s)", hook.Command, hook.OnError, onErrorChoices(entry))
+			}
+		}
+	}
+	return nil
+}
+
+func onErrorChoices(entry bool) string {
+	if entry {
+		return "skip, abort or ignore"
+	}
+	return "abort or ignore"
+}
+
+// Hooks of a backup run, run once however many destinations it has
+type hookRun struct {
+	ctx     context.Context // the run's, so its timeout also stops hooks
+	homeDir string
+	env     []string // VY_* variables every hook gets
+	verbose bool
+	out     io.Writer
+	hooks   Hooks // of the profile
+
+	reports  []HookReport
+	entries  []BackupEntry     // entries whose pre hooks ran, in profile order
+	skipped  map[string]string // by remote name, why the entry was left out
+	prepared bool
+	aborted  error // from a pre hook of an entry, every destination stops with it
+}
+
+func newHookRun(ctx context.Context, homeDir string, hooks Hooks, env []string, verbose bool, out io.Writer) *hookRun {
+	return &hookRun{
+		ctx:     ctx,
+		homeDir: homeDir,
+		env:     env,
+		verbose: verbose,
+		out:     out,
+		hooks:   hooks,
+		skipped: make(map[string]string),
+	}
+}
+
+// Run the pre hooks of the run, an error means it must stop
+func (h *hookRun) pre() error {
+	for _, hook := range h.hooks.Pre {
+		report := h.run(hookPre, nil, hook, nil)
+		if report.Status != "ok" && hook.OnError != hookIgnore {
+			return fmt.Errorf("pre hook %q failed, backup aborted: %s", hook.Command, report.Error)
+		}
+	}
+	return nil
+}
+
+// Run the pre hooks of the entries once for the whole run, before anything is dumped or read.
+// Entries whose hook failed with on_error = "skip" are recorded in skipped.
+func (h *hookRun) preEntries(entries []BackupEntry) error {
+	if h.prepared {
+		return h.aborted
+	}
+	h.prepared = true
+
+	for _, entry := range entries {
+		h.entries = append(h.entries, entry)
+		for _, hook := range entry.Hooks.Pre {
+			report := h.run(hookPre, &entry, hook, nil)
+			if report.Status == "ok" || hook.OnError == hookIgnore {
+				continue
+			}
+			if hook.OnError == hookAbort {
+				h.aborted = fmt.Errorf("pre hook %q of %s failed, backup aborted: %s", hook.Command, entry.Name, report.Error)
+				return h.aborted
+			}
+			h.skipped[entry.Remote] = "pre hook failed: " + report.Error
+			break
+		}
+	}
+	return nil
+}
+
+// Run the post and on_failure hooks of the entries and then of the run. failed holds
+// the remote names of entries that did not make it to every destination.
+func (h *hookRun) post(code int, failed map[string]bool) {
+	for i := range h.entries {
+		entry := &h.entries[i]
+		status := exitStatus[ExitSuccess]
+		if failed[entry.Remote] {
+			status = exitStatus[ExitFailed]
+		}
+		env := []string{"VY_STATUS=" + status}
+		for _, hook := range entry.Hooks.Post {
+			h.run(hookPost, entry, hook, env)
+		}
+		if failed[entry.Remote] {
+			for _, hook := range entry.Hooks.OnFailure {
+				h.run(hookOnFailure, entry, hook, env)
+			}
+		}
+	}
+
+	env := []string{"VY_STATUS=" + exitStatus[code]}
+	for _, hook := range h.hooks.Post {
+		h.run(hookPost, nil, hook, env)
+	}
+	if code != ExitSuccess {
+		for _, hook := range h.hooks.OnFailure {
+			h.run(hookOnFailure, nil, hook, env)
+		}
+	}
+}
+
+// Run one hook, print how it went and keep its output for the report
+func (h *hookRun) run(kind string, entry *BackupEntry, hook Hook, env []string) HookReport {
+	report := HookReport{Hook: kind, Command: hook.Command, Status: "ok"}
+	env = append(append(os.Environ(), h.env...), env...)
+	name := kind + " hook"
+	if entry != nil {
+		report.Entry = entry.Name
+		env = append(env, "VY_ENTRY="+entry.Name, "VY_ENTRY_PATH="+entry.Path)
+		name = fmt.Sprintf("%s hook of %s", kind, entry.Name)
+	}
+	if h.verbose {
+		fmt.Fprintf(h.out, "🪝 Running %s: %s\n", name, hook.Command)
+	}
+
+	timeout := defaultHookTimeout
+	if hook.Timeout != "" {
+		timeout, _ = time.ParseDuration(hook.Timeout)
+	}
+	start := time.Now()
+	output, code, err := runHookCommand(h.ctx, hook.Command, h.homeDir, env, timeout)
+	report.Duration = time.Since(start).Seconds()
+	report.ExitCode = code
+	report.Output = output
+	if err != nil {
+		report.Status = "failed"
+		if errors.Is(err, context.DeadlineExceeded) {
+			report.Status = "timeout"
+		}
+		report.Error = err.Error()
+		fmt.Fprintf(h.out, "⚠️  %s failed: %s\n", strings.ToUpper(name[:1])+name[1:], report.Error)
+		if output != "" {
+			fmt.Fprintf(h.out, "    %s\n", strings.ReplaceAll(strings.TrimRight(output, "\n"), "\n", "\n    "))
+		}
+	}
+
+	h.reports = append(h.reports, report)
+	return report
+}
+
+// Run command with sh -c, killing it and whatever it started once timeout or ctx is up.
+// Output is read until the shell exits, a process it left running in the background
+// doesn't keep the hook going, but it loses its stdout and stderr.
+func runHookCommand(run context.Context, command, dir string, env []string, timeout time.Duration) (string, int, error) {
+	ctx, cancel := context.WithTimeout(run, timeout)
+	defer cancel()
+
+	// A pipe rather than a buffer, so Wait doesn't wait for everything holding it to exit
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return "", -1, err
+	}
+	defer reader.Close()
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	// In its own process group, so a timeout also stops what the hook started
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err = cmd.Start()
+	writer.Close()
+	if err != nil {
+		return "", -1, err
+	}
+
+	var output bytes.Buffer
+	read := make(chan struct{})
+	go func() {
+		io.Copy(&output, reader)
+		close(read)
+	}()
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		err = fmt.Errorf("timed out after %s: %w", timeout, ctx.Err())
+		if run.Err() != nil {
+			err = fmt.Errorf("backup timed out: %w", run.Err())
+		}
+	}
+	// Take what the shell wrote before it exited, then stop reading
+	reader.SetReadDeadline(time.Now().Add(hookOutputGrace))
+	<-read
+
+	code := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+		err = fmt.Errorf("exit status %d", code)
+	} else if err != nil {
+		code = -1
+	}
+
+	text := output.String()
+	if len(text) > maxHookOutput {
+		text = "..." + text[len(text)-maxHookOutput:]
+	}
+	return text, code, err
+}
+
+// Hooks a backup would run, for vy backup --dry-run
+func printHookPlan(out io.Writer, hooks Hooks, entries []BackupEntry) {
+	var lines []string
+	add := func(kind, entry string, list []Hook) {
+		if entry != "" {
+			kind += " of " + entry
+		}
+		for _, hook := range list {
+			lines = append(lines, fmt.Sprintf("  %-24s %s", kind, hook.Command))
+		}
+	}
+	add(hookPre, "", hooks.Pre)
+	for _, entry := range entries {
+		add(hookPre, entry.Name, entry.Hooks.Pre)
+	}
+	for _, entry := range entries {
+		add(hookPost, entry.Name, entry.Hooks.Post)
+		add(hookOnFailure, entry.Name, entry.Hooks.OnFailure)
+	}
+	add(hookPost, "", hooks.Post)
+	add(hookOnFailure, "", hooks.OnFailure)
+	if len(lines) == 0 {
+		return
+	}
+
+	fmt.Fprintln(out, "\nHooks that would run (not run in a dry run):")
+	fmt.Fprintln(out, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHookCommand(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		command string
+		timeout time.Duration
+		output  string // what the output starts with
+		code    int
+		err     string // what the error contains, empty for none
+	}{
+		{"success", "echo hello", time.Second, "hello\n", 0, ""},
+		{"stderr", "echo oops >&2", time.Second, "oops\n", 0, ""},
+		{"exit code", "echo failing; exit 3", time.Second, "failing\n", 3, "exit status 3"},
+		{"environment", `echo "$VY_PROFILE"`, time.Second, "work\n", 0, ""},
+		{"working directory", "pwd", time.Second, dir + "\n", 0, ""},
+		{"timeout", "echo started; sleep 10", 200 * time.Millisecond, "started\n", -1, "timed out after 200ms"},
+		{"long output", "head -c 40000 /dev/zero | tr '\\0' x", time.Second, "...xxx", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			output, code, err := runHookCommand(context.Background(), tt.command, dir, []string{"VY_PROFILE=work", "PATH=" + os.Getenv("PATH")}, tt.timeout)
+			if !strings.HasPrefix(output, tt.output) || code != tt.code {
+				t.Errorf("output %q, code %d, want %q, %d", output, code, tt.output, tt.code)
+			}
+			if len(output) > maxHookOutput+3 {
+				t.Errorf("output is %d bytes, want at most %d", len(output), maxHookOutput+3)
+			}
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+			if elapsed := time.Since(start); elapsed > tt.timeout+2*time.Second {
+				t.Errorf("took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestRunHookCommandBackground(t *testing.T) {
+	// A daemon started by the hook keeps the pipe open, the hook is still done once the shell exits
+	start := time.Now()
+	output, code, err := runHookCommand(context.Background(), "echo starting; sleep 5 & echo started", "", []string{"PATH=" + os.Getenv("PATH")}, 10*time.Second)
+	if err != nil || code != 0 || output != "starting\nstarted\n" {
+		t.Errorf("output %q, code %d, error %v", output, code, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %s for the background process", elapsed)
+	}
+}
+
+func TestRunHookCommandKillsGroup(t *testing.T) {
+	// What the hook started goes with it when it times out
+	marker := filepath.Join(t.TempDir(), "marker")
+	_, _, err := runHookCommand(context.Background(), "(sleep 1; touch "+marker+") & sleep 10", "", []string{"PATH=" + os.Getenv("PATH")}, 200*time.Millisecond)
+	if err == nil {
+		t.Fatalf("hook did not time out")
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("a process started by the hook outlived its timeout")
+	}
+}
+
+func TestRunHookCommandRunTimedOut(t *testing.T) {
+	run, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, _, err := runHookCommand(run, "sleep 10", "", []string{"PATH=" + os.Getenv("PATH")}, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "backup timed out") {
+		t.Errorf("error = %v, want backup timed out", err)
+	}
+}
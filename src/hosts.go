@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Every machine keeps its snapshots in Backups/ubuntu-settings/hosts/<host>,
+// snapshots written before that stay directly in Backups/ubuntu-settings
+const hostsDir = backupDir + "/hosts"
+
+// [host] in backup.toml
+type HostConfig struct {
+	Alias string `toml:"alias"` // name of this machine on the remote, e.g. "laptop"
+	ID    string `toml:"id"`    // what names it without an alias: hostname (default) or machine-id
+}
+
+var unsafeHostChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func hostRoot(host string) string {
+	return hostsDir + "/" + host
+}
+
+// Name of this machine on the remote
+func currentHost(config HostConfig) (string, error) {
+	name := config.Alias
+	if name == "" {
+		var err error
+		switch config.ID {
+		case "", "hostname":
+			name, err = os.Hostname()
+		case "machine-id":
+			var data []byte
+			data, err = os.ReadFile("/etc/machine-id")
+			name = string(data)
+		default:
+			return "", fmt.Errorf("unknown host id: %s (use hostname or machine-id)", config.ID)
+		}
+		if err != nil {
+			return "", fmt.Errorf("Error getting host name: %w", err)
+		}
+	}
+
+	name = strings.Trim(unsafeHostChars.ReplaceAllString(strings.TrimSpace(name), "-"), "-.")
+	if name == "" {
+		return "", fmt.Errorf("this machine has no usable name, set an alias under [host] in ~/.config/vy/backup.toml")
+	}
+	return name, nil
+}
+
+// Host from the config, for commands that only read it
+func configuredHost() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("Error getting home directory: %w", err)
+	}
+	config, err := loadBackupConfig(homeDir)
+	if err != nil {
+		return "", err
+	}
+	return currentHost(config.Host)
+}
+
+// Directory of the snapshot an unchanged entry was first uploaded to, next to snapshotDir
+func storedInDir(snapshotDir, storedIn string) string {
+	return path.Dir(snapshotDir) + "/" + storedIn
+}
+
+// Names of the machines with backups on the drive
+func listHosts(store Storage) ([]string, error) {
+	files, err := store.List(hostsDir, false)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, f := range files {
+		if f.IsDir {
+			hosts = append(hosts, f.Path)
+		}
+	}
+	return hosts, nil
+}
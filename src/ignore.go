@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Name of the gitignore-style files read inside backed up directories
+const ignoreFileName = ".vyignore"
+
+// One line of an ignore file or of the global ignore list
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool // !pattern, brings back what an earlier rule left out
+	dirOnly bool // pattern/, only matches directories
+}
+
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	return (isDir || !r.dirOnly) && r.re.MatchString(rel)
+}
+
+// Parse a line the way git reads .gitignore: a pattern without a slash matches at any depth,
+// one with a slash is relative to the directory of the file, ** crosses directories.
+// Blank lines and comments give false.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+
+	var re strings.Builder
+	if strings.Contains(line, "/") {
+		re.WriteString("^")
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		re.WriteString("^(.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case strings.HasPrefix(line[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case line[i:] == "/**":
+			re.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(line[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[' && strings.IndexByte(line[i+1:], ']') > 0:
+			end := i + 1 + strings.IndexByte(line[i+1:], ']')
+			class := line[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		case c == '\\' && i+1 < len(line):
+			re.WriteString(regexp.QuoteMeta(line[i+1 : i+2]))
+			i++
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return rule, false
+	}
+	rule.re = compiled
+	return rule, true
+}
+
+func parseIgnoreRules(lines []string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range lines {
+		if rule, ok := parseIgnoreRule(line); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// Which paths of an entry are left out: the global ignore list from the config, then the
+// .vyignore files from the entry directory down, the last matching rule winning like in git.
+// Nothing inside an ignored directory can be brought back. A nil matcher ignores nothing.
+type ignoreMatcher struct {
+	root   string // local directory paths are relative to, empty when .vyignore files aren't read
+	global []ignoreRule
+	dirs   map[string][]ignoreRule // rules of the .vyignore file in each directory, by path relative to root
+}
+
+func newIgnoreMatcher(root string, global []string) *ignoreMatcher {
+	return &ignoreMatcher{root: root, global: parseIgnoreRules(global), dirs: make(map[string][]ignoreRule)}
+}
+
+// Whether rel, a slash separated path relative to the root, is left out
+func (m *ignoreMatcher) ignored(rel string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.match(rel, isDir)
+}
+
+func (m *ignoreMatcher) match(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range m.global {
+		if rule.matches(rel, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	if m.root == "" {
+		return ignored
+	}
+
+	// .vyignore files of rel's parent directories, each matching paths relative to itself
+	dir := ""
+	for {
+		sub := rel
+		if dir != "" {
+			sub = strings.TrimPrefix(rel, dir+"/")
+		}
+		for _, rule := range m.rules(dir) {
+			if rule.matches(sub, isDir) {
+				ignored = !rule.negate
+			}
+		}
+		i := strings.IndexByte(sub, '/')
+		if i < 0 {
+			return ignored
+		}
+		dir = path.Join(dir, sub[:i])
+	}
+}
+
+// Rules of the .vyignore file in dir, read once
+func (m *ignoreMatcher) rules(dir string) []ignoreRule {
+	if rules, ok := m.dirs[dir]; ok {
+		return rules
+	}
+	var lines []string
+	if f, err := os.Open(filepath.Join(m.root, filepath.FromSlash(dir), ignoreFileName)); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		f.Close()
+	}
+	rules := parseIgnoreRules(lines)
+	m.dirs[dir] = rules
+	return rules
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseIgnoreRule(t *testing.T) {
+	tests := []struct {
+		line    string
+		ok      bool
+		negate  bool
+		dirOnly bool
+	}{
+		{"", false, false, false},
+		{"   ", false, false, false},
+		{"# comment", false, false, false},
+		{"*.log", true, false, false},
+		{"*.log  ", true, false, false},
+		{"!keep.log", true, true, false},
+		{"build/", true, false, true},
+		{"!build/", true, true, true},
+		{"/", false, false, false},
+	}
+	for _, tt := range tests {
+		rule, ok := parseIgnoreRule(tt.line)
+		if ok != tt.ok || ok && (rule.negate != tt.negate || rule.dirOnly != tt.dirOnly) {
+			t.Errorf("parseIgnoreRule(%q) = negate %v, dirOnly %v, ok %v, want %v, %v, %v",
+				tt.line, rule.negate, rule.dirOnly, ok, tt.negate, tt.dirOnly, tt.ok)
+		}
+	}
+}
+
+func TestIgnoreRuleMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.log", "debug.log", false, true},
+		{"*.log", "a/b/debug.log", false, true},
+		{"*.log", "debug.log.1", false, false},
+		{"/*.log", "debug.log", false, true},
+		{"/*.log", "a/debug.log", false, false},
+		{"doc/*.txt", "doc/notes.txt", false, true},
+		{"doc/*.txt", "doc/sub/notes.txt", false, false},
+		{"doc/*.txt", "x/doc/notes.txt", false, false},
+		{"**/cache", "a/b/cache", true, true},
+		{"**/cache", "cache", true, true},
+		{"logs/**", "logs/a/b", false, true},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"file?.txt", "file1.txt", false, true},
+		{"file?.txt", "file10.txt", false, false},
+		{"[abc].txt", "b.txt", false, true},
+		{"[!abc].txt", "b.txt", false, false},
+		{"[!abc].txt", "d.txt", false, true},
+		{`\#hash`, "#hash", false, true},
+	}
+	for _, tt := range tests {
+		rule, ok := parseIgnoreRule(tt.pattern)
+		if !ok {
+			t.Errorf("parseIgnoreRule(%q) failed", tt.pattern)
+			continue
+		}
+		if got := rule.matches(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%q matches %q (dir %v) = %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		ignoreFileName:               "*.tmp\n!keep.tmp\n",
+		"sub/" + ignoreFileName:      "/local.txt\nkeep.tmp\n",
+		"sub/deep/" + ignoreFileName: "!*.swp\n",
+	})
+	m := newIgnoreMatcher(root, []string{"*.swp", "node_modules/", "!important.swp"})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"file.txt", false, false},
+		{"file.tmp", false, true},
+		{"keep.tmp", false, false},
+		{"sub/keep.tmp", false, true},     // a deeper .vyignore wins
+		{"sub/local.txt", false, true},    // anchored to sub/
+		{"local.txt", false, false},       // not relative to sub/
+		{"sub/x/local.txt", false, false}, // anchored, so not at any depth
+		{"undo/file.swp", false, true},    // global list
+		{"important.swp", false, false},   // brought back in the global list
+		{"sub/deep/file.swp", false, false},
+		{"node_modules", true, true},
+		{"app/node_modules", true, true},
+		{"app/node_modules/x/keep.tmp", false, true}, // nothing comes back inside an ignored directory
+		{"node_modules", false, false},               // only directories
+	}
+	for _, tt := range tests {
+		if got := m.ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%q, dir %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	var none *ignoreMatcher
+	if none.ignored("file.tmp", false) {
+		t.Errorf("a nil matcher ignored a file")
+	}
+
+	// Without a root only the global list counts
+	global := newIgnoreMatcher("", []string{"*.swp"})
+	if global.ignored("file.tmp", false) || !global.ignored(filepath.ToSlash("a/file.swp"), false) {
+		t.Errorf("a matcher without a root read .vyignore files or missed the global list")
+	}
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+type ListOptions struct {
+	Drive    string
+	Host     string // machine whose snapshots to list, empty means this one
+	Hosts    bool   // list the machines instead of snapshots
+	Snapshot string // list the entries of this snapshot, "latest" for the latest one
+	Entry    string // list the files of this entry of Snapshot
+	JSON     bool
+}
+
+// One machine with backups on the drive
+type hostSummary struct {
+	Host      string `json:"host"`
+	Current   bool   `json:"current"`          // this machine
+	Legacy    bool   `json:"legacy,omitempty"` // snapshots written before hosts existed
+	Snapshots int    `json:"snapshots"`
+	Latest    string `json:"latest,omitempty"`
+	Hostname  string `json:"hostname,omitempty"` // as recorded in the latest manifest
+}
+
+// One snapshot, summed up from its manifest
+type snapshotSummary struct {
+	Snapshot  string    `json:"snapshot"`
+	CreatedAt time.Time `json:"created_at"`
+	Entries   int       `json:"entries"`
+	Files     int       `json:"files"`
+	Size      int64     `json:"size"`
+	Failed    int       `json:"failed"`
+	Status    string    `json:"status"` // ok, partial, failed or no manifest
+	Archive   string    `json:"archive,omitempty"`
+}
+
+func hostSummaries(store Storage, current string) ([]hostSummary, error) {
+	hosts, err := listHosts(store)
+	if err != nil {
+		return nil, err
+	}
+
+	var summaries []hostSummary
+	add := func(summary hostSummary, root string) error {
+		snapshots, err := listSnapshots(store, root)
+		if err != nil {
+			return err
+		}
+		summary.Snapshots = len(snapshots)
+		if len(snapshots) > 0 {
+			summary.Latest = snapshots[len(snapshots)-1]
+			if manifest, err := fetchManifest(store, root+"/"+summary.Latest, manifestName); err == nil {
+				summary.Hostname = manifest.Hostname
+			}
+		}
+		if summary.Snapshots > 0 || !summary.Legacy {
+			summaries = append(summaries, summary)
+		}
+		return nil
+	}
+	for _, host := range hosts {
+		if err := add(hostSummary{Host: host, Current: host == current}, hostRoot(host)); err != nil {
+			return nil, err
+		}
+	}
+	if err := add(hostSummary{Legacy: true}, backupDir); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+func summarizeSnapshot(store Storage, root, name string) snapshotSummary {
+	summary := snapshotSummary{Snapshot: name, Status: "no manifest"}
+	if t, ok := parseSnapshotName(name); ok {
+		summary.CreatedAt = t
+	}
+	manifest, err := fetchManifest(store, root+"/"+name, manifestName)
+	if err != nil {
+		return summary
+	}
+
+	summary.CreatedAt = manifest.CreatedAt
+	summary.Entries = len(manifest.Entries)
+	summary.Archive = manifest.Archive
+	for _, entry := range manifest.Entries {
+		summary.Files += entry.FileCount
+		summary.Size += entry.Size
+		if entry.Error != "" {
+			summary.Failed++
+		}
+	}
+	switch {
+	case summary.Failed == 0:
+		summary.Status = "ok"
+	case summary.Failed == summary.Entries:
+		summary.Status = "failed"
+	default:
+		summary.Status = "partial"
+	}
+	return summary
+}
+
+// Show what is on the drive: machines, snapshots of one of them, the entries of a snapshot or the files of an entry
+func HandleList(opts ListOptions) {
+	store, err := newStorage(opts.Drive)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	current, err := configuredHost()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if opts.Hosts {
+		hosts, err := hostSummaries(store, current)
+		if err != nil {
+			fmt.Printf("Error listing hosts: %v\n", err)
+			return
+		}
+		if opts.JSON {
+			printJSON(hosts)
+			return
+		}
+		printHosts(hosts, opts.Drive)
+		return
+	}
+
+	host := current
+	if opts.Host != "" {
+		host = opts.Host
+	}
+	legacy := host == current
+
+	if opts.Snapshot == "" {
+		root, snapshots, err := hostSnapshots(store, host, legacy)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		summaries := make([]snapshotSummary, 0, len(snapshots))
+		for _, name := range snapshots {
+			summaries = append(summaries, summarizeSnapshot(store, root, name))
+		}
+		if opts.JSON {
+			printJSON(summaries)
+			return
+		}
+		printSnapshots(summaries, host, opts.Drive)
+		return
+	}
+
+	snapshot := opts.Snapshot
+	if snapshot == "latest" {
+		snapshot = ""
+	}
+	snapshotDir, err := resolveSnapshotDir(store, host, snapshot, legacy)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	manifest, err := fetchManifest(store, snapshotDir, manifestName)
+	if err != nil {
+		fmt.Printf("❌ No manifest found in %s on %s, nothing to list\n  Error: %v\n", snapshotDir, opts.Drive, err)
+		return
+	}
+
+	if opts.Entry == "" {
+		if opts.JSON {
+			printJSON(manifest)
+			return
+		}
+		printEntries(manifest, snapshotDir)
+		return
+	}
+
+	entry := manifest.Entry(opts.Entry)
+	if entry == nil {
+		fmt.Printf("No entry %s in snapshot %s\n", opts.Entry, manifest.Snapshot)
+		return
+	}
+	if opts.JSON {
+		printJSON(entry)
+		return
+	}
+	printFiles(entry)
+}
+
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing JSON: %v\n", err)
+	}
+}
+
+func printHosts(hosts []hostSummary, drive string) {
+	if len(hosts) == 0 {
+		fmt.Printf("No backups found on %s\n", drive)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSNAPSHOTS\tLATEST\tHOSTNAME")
+	for _, host := range hosts {
+		name := host.Host
+		switch {
+		case host.Legacy:
+			name = "(before hosts)"
+		case host.Current:
+			name += " (this machine)"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", name, host.Snapshots, orDash(host.Latest), orDash(host.Hostname))
+	}
+	w.Flush()
+
+	fmt.Println("\nRestore another machine's backup with vy restore --host <host>")
+}
+
+func printSnapshots(snapshots []snapshotSummary, host, drive string) {
+	if len(snapshots) == 0 {
+		fmt.Printf("No snapshots of %s on %s\n", host, drive)
+		return
+	}
+
+	fmt.Printf("Snapshots of %s on %s\n\n", host, drive)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SNAPSHOT\tDATE\tENTRIES\tFILES\tSIZE\tSTATUS")
+	for _, s := range snapshots {
+		status := s.Status
+		if s.Failed > 0 {
+			status = fmt.Sprintf("%s (%d failed)", s.Status, s.Failed)
+		}
+		if s.Archive != "" {
+			status += ", " + s.Archive
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", s.Snapshot, s.CreatedAt.Local().Format("2006-01-02 15:04"), s.Entries, s.Files, formatSize(s.Size), status)
+	}
+	w.Flush()
+	fmt.Println("\nList the entries of one with vy backup list -s <snapshot>")
+}
+
+func printEntries(manifest *Manifest, snapshotDir string) {
+	fmt.Printf("Snapshot %s (%s), %d entries\n\n", manifest.Snapshot, snapshotDir, len(manifest.Entries))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFILES\tSIZE\tSTATUS\tSTORED IN")
+	for _, entry := range manifest.Entries {
+		status := "ok"
+		switch {
+		case entry.Error != "":
+			status = "failed: " + firstLine(entry.Error)
+		case entry.Encryption != nil:
+			status = "ok, encrypted"
+		}
+		stored := "this snapshot"
+		switch {
+		case entry.StoredIn != "":
+			stored = entry.StoredIn
+		case manifest.Archive != "":
+			stored = manifest.Archive
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", entry.Name, entry.FileCount, formatSize(entry.Size), status, stored)
+	}
+	w.Flush()
+	fmt.Printf("\nList the files of one with vy backup list -s %s -e <name>\n", manifest.Snapshot)
+}
+
+func printFiles(entry *ManifestEntry) {
+	fmt.Printf("%s from %s, %d files, %s\n\n", entry.Name, entry.Source, entry.FileCount, formatSize(entry.Size))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tSIZE\tMODE\tMODIFIED\tSHA256")
+	for _, file := range entry.Files {
+		sum := file.SHA256
+		if len(sum) > 12 {
+			sum = sum[:12]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", file.Path, formatSize(file.Size), file.Mode, file.ModTime.Local().Format("2006-01-02 15:04"), sum)
+	}
+	w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
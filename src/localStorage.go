@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage in a local directory, e.g. an external disk or a mounted NAS share
+type localStorage struct {
+	uri  string
+	root string
+}
+
+func newLocalStorage(uri string) (*localStorage, error) {
+	root := filepath.Clean(strings.TrimPrefix(uri, fileScheme))
+	if !filepath.IsAbs(root) {
+		return nil, fmt.Errorf("%s is not an absolute path, use file:///path/to/dir", uri)
+	}
+
+	// Refuse to write into the mount point of a disk that is not mounted
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("backup directory %s is not available: %w", root, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("backup destination %s is not a directory", root)
+	}
+	return &localStorage{uri: uri, root: root}, nil
+}
+
+func (s *localStorage) String() string {
+	return s.uri
+}
+
+func (s *localStorage) path(remotePath string) string {
+	return filepath.Join(s.root, filepath.FromSlash(remotePath))
+}
+
+func (s *localStorage) Put(localPath, remotePath string, filter Filter) error {
+	return s.PutProgress(localPath, remotePath, filter, nil)
+}
+
+func (s *localStorage) PutProgress(localPath, remotePath string, filter Filter, progress func(bytes int64)) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	dest := s.path(remotePath)
+
+	// Bytes of the files copied before the current one
+	var copied int64
+	put := func(src, dst string, size int64) error {
+		var report func(int64)
+		if progress != nil {
+			report = func(n int64) { progress(copied + n) }
+		}
+		if err := copyWithParents(src, dst, report); err != nil {
+			return err
+		}
+		copied += size
+		return nil
+	}
+
+	if !info.IsDir() {
+		if !filter.allows(filepath.Base(localPath)) {
+			return nil
+		}
+		return put(localPath, filepath.Join(dest, filepath.Base(localPath)), info.Size())
+	}
+
+	return filepath.Walk(localPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localPath, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && filter.skipsDir(filepath.ToSlash(rel)) {
+			return filepath.SkipDir
+		}
+		// Like rclone, only regular files are copied
+		if !info.Mode().IsRegular() || !filter.allows(filepath.ToSlash(rel)) {
+			return nil
+		}
+		return put(path, filepath.Join(dest, rel), info.Size())
+	})
+}
+
+func (s *localStorage) Get(remotePath, localPath string) error {
+	src := s.path(remotePath)
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return notFound(remotePath)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return copyWithParents(src, filepath.Join(localPath, filepath.Base(src)), nil)
+	}
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		return copyWithParents(path, filepath.Join(localPath, rel), nil)
+	})
+}
+
+func (s *localStorage) List(remotePath string, recursive bool) ([]RemoteFile, error) {
+	dir := s.path(remotePath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, notFound(remotePath)
+	}
+
+	var files []RemoteFile
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, RemoteFile{
+			Path:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+		})
+		if info.IsDir() && !recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (s *localStorage) Mkdir(remotePath string) error {
+	return os.MkdirAll(s.path(remotePath), 0755)
+}
+
+func (s *localStorage) Stat(remotePath string) (RemoteFile, error) {
+	info, err := os.Stat(s.path(remotePath))
+	if os.IsNotExist(err) {
+		return RemoteFile{}, notFound(remotePath)
+	}
+	if err != nil {
+		return RemoteFile{}, err
+	}
+	return RemoteFile{
+		Path:    filepath.Base(remotePath),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}, nil
+}
+
+func (s *localStorage) Delete(remotePath string) error {
+	path := s.path(remotePath)
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return notFound(remotePath)
+	}
+	return os.RemoveAll(path)
+}
+
+func (s *localStorage) Hashes(remotePath string) (map[string]string, error) {
+	files, err := s.List(remotePath, true)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string)
+	for _, f := range files {
+		if f.IsDir {
+			continue
+		}
+		sum, err := hashFile(filepath.Join(s.path(remotePath), filepath.FromSlash(f.Path)))
+		if err != nil {
+			return nil, err
+		}
+		hashes[f.Path] = sum
+	}
+	return hashes, nil
+}
+
+// Copy a single file, creating parent directories and keeping its mode and mtime.
+// report, when set, gets the bytes copied so far.
+func copyWithParents(src, dst string, report func(bytes int64)) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := copyFileProgress(src, dst, report); err != nil {
+		return err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// Write files, by slash separated path relative to dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newTestStorage(t *testing.T) *localStorage {
+	t.Helper()
+	store, err := newLocalStorage(fileScheme + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func remotePaths(files []RemoteFile) []string {
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNewLocalStorage(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	writeFiles(t, filepath.Dir(file), map[string]string{"file": "x"})
+
+	tests := []struct {
+		name string
+		uri  string
+		ok   bool
+	}{
+		{"directory", fileScheme + t.TempDir(), true},
+		{"relative path", fileScheme + "backups", false},
+		{"missing directory", fileScheme + filepath.Join(t.TempDir(), "missing"), false},
+		{"file", fileScheme + file, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newLocalStorage(tt.uri)
+			if (err == nil) != tt.ok {
+				t.Errorf("newLocalStorage(%q) error = %v, want ok %v", tt.uri, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLocalStoragePut(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		local  string // uploaded path, relative to the files
+		filter Filter
+		want   []string
+	}{
+		{
+			name:  "file",
+			files: map[string]string{".bashrc": "a"},
+			local: ".bashrc",
+			want:  []string{".bashrc"},
+		},
+		{
+			name:  "directory",
+			files: map[string]string{"nvim/init.lua": "a", "nvim/lua/plugins.lua": "b"},
+			local: "nvim",
+			want:  []string{"init.lua", "lua", "lua/plugins.lua"},
+		},
+		{
+			name:   "exclude",
+			files:  map[string]string{"nvim/init.lua": "a", "nvim/debug.log": "b"},
+			local:  "nvim",
+			filter: Filter{Exclude: []string{"*.log"}},
+			want:   []string{"init.lua"},
+		},
+		{
+			name:   "include",
+			files:  map[string]string{"ssh/config": "a", "ssh/id_ed25519": "b", "ssh/known_hosts": "c"},
+			local:  "ssh",
+			filter: Filter{Include: []string{"config", "known_hosts"}},
+			want:   []string{"config", "known_hosts"},
+		},
+		{
+			name:   "ignored directory",
+			files:  map[string]string{"code/main.go": "a", "code/.git/HEAD": "b"},
+			local:  "code",
+			filter: Filter{ignore: newIgnoreMatcher("", []string{".git/"})},
+			want:   []string{"main.go"},
+		},
+		{
+			name:   "filtered file",
+			files:  map[string]string{"debug.log": "a"},
+			local:  "debug.log",
+			filter: Filter{Exclude: []string{"*.log"}},
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := t.TempDir()
+			writeFiles(t, src, tt.files)
+			store := newTestStorage(t)
+
+			if err := store.Put(filepath.Join(src, tt.local), "backup", tt.filter); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			files, err := store.List("backup", true)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("List: %v", err)
+			}
+			if got := remotePaths(files); !equalStrings(got, tt.want) {
+				t.Errorf("uploaded %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalStorageGet(t *testing.T) {
+	store := newTestStorage(t)
+	writeFiles(t, store.root, map[string]string{"backup/nvim/init.lua": "a", "backup/nvim/lua/plugins.lua": "b"})
+
+	tests := []struct {
+		name   string
+		remote string
+		want   map[string]string
+	}{
+		{"file", "backup/nvim/init.lua", map[string]string{"init.lua": "a"}},
+		{"directory", "backup/nvim", map[string]string{"init.lua": "a", "lua/plugins.lua": "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			if err := store.Get(tt.remote, dst); err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			for name, want := range tt.want {
+				got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+				if err != nil || string(got) != want {
+					t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+				}
+			}
+		})
+	}
+
+	if err := store.Get("backup/missing", t.TempDir()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get of a missing path: error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestLocalStorageList(t *testing.T) {
+	store := newTestStorage(t)
+	writeFiles(t, store.root, map[string]string{"hosts/a/one": "1", "hosts/a/two/three": "3", "hosts/b/four": "4"})
+
+	tests := []struct {
+		name      string
+		remote    string
+		recursive bool
+		want      []string
+	}{
+		{"top level", "hosts", false, []string{"a", "b"}},
+		{"recursive", "hosts/a", true, []string{"one", "two", "two/three"}},
+		{"not recursive", "hosts/a", false, []string{"one", "two"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := store.List(tt.remote, tt.recursive)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if got := remotePaths(files); !equalStrings(got, tt.want) {
+				t.Errorf("List(%q, %v) = %v, want %v", tt.remote, tt.recursive, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := store.List("missing", false); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("List of a missing path: error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestLocalStorageDelete(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote string
+		want   []string // left under the root
+	}{
+		{"file", "snapshots/one/file", []string{"snapshots", "snapshots/one", "snapshots/two", "snapshots/two/file"}},
+		{"directory", "snapshots/one", []string{"snapshots", "snapshots/two", "snapshots/two/file"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStorage(t)
+			writeFiles(t, store.root, map[string]string{"snapshots/one/file": "1", "snapshots/two/file": "2"})
+
+			if err := store.Delete(tt.remote); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			files, err := store.List("", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := remotePaths(files); !equalStrings(got, tt.want) {
+				t.Errorf("left %v, want %v", got, tt.want)
+			}
+			if err := store.Delete(tt.remote); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Delete again: error = %v, want os.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestLocalStorageHashes(t *testing.T) {
+	store := newTestStorage(t)
+	writeFiles(t, store.root, map[string]string{"backup/a": "same", "backup/dir/b": "same", "backup/dir/c": "other"})
+
+	hashes, err := store.Hashes("backup")
+	if err != nil {
+		t.Fatalf("Hashes: %v", err)
+	}
+	if len(hashes) != 3 {
+		t.Fatalf("Hashes = %v, want 3 files", hashes)
+	}
+	want, err := hashFile(filepath.Join(store.root, "backup", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes["a"] != want || hashes["dir/b"] != want {
+		t.Errorf("files with the same content hash to %q and %q, want %q", hashes["a"], hashes["dir/b"], want)
+	}
+	if hashes["dir/c"] == want {
+		t.Errorf("files with different content have the same hash")
+	}
+
+	if _, err := store.Hashes("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Hashes of a missing path: error = %v, want os.ErrNotExist", err)
+	}
+}
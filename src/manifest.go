@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Version of vy recorded in every manifest, set at build time with
+// go build -ldflags "-X github.com/vaibhavyadav-dev/vy-cli/src.Version=..."
+var Version = "dev"
+
+// Name of the manifest uploaded next to the backed up entries
+const manifestName = "manifest.json"
+
+// Bumped whenever the manifest layout changes in a way readers must know about
+const manifestFormat = 4
+
+// What a single backup run uploaded
+type Manifest struct {
+	Format        int             `json:"format"`
+	VyVersion     string          `json:"vy_version"`
+	Hostname      string          `json:"hostname"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Snapshot      string          `json:"snapshot"`
+	Archive       string          `json:"archive,omitempty"` // set when every entry is inside this single archive
+	ArchiveSHA256 string          `json:"archive_sha256,omitempty"`
+	Entries       []ManifestEntry `json:"entries"`
+}
+
+type ManifestEntry struct {
+	Name      string         `json:"name"` // directory name on the remote
+	Source    string         `json:"source"`
+	FileCount int            `json:"file_count"`
+	Size      int64          `json:"size"`
+	Files     []ManifestFile `json:"files"`
+	Type      string         `json:"type,omitempty"`  // entryTypeFile or entryTypeDir, empty in older manifests
+	Error     string         `json:"error,omitempty"` // set when the upload failed
+
+	// Set when the entry was uploaded as a single encrypted archive
+	Encryption *ManifestEncryption `json:"encryption,omitempty"`
+
+	// Set when the entry was unchanged and its data lives in that earlier snapshot
+	StoredIn string `json:"stored_in,omitempty"`
+}
+
+// What the source of an entry is, restore puts a single file back in place of the entry directory
+const (
+	entryTypeFile = "file"
+	entryTypeDir  = "dir"
+)
+
+type ManifestFile struct {
+	Path    string      `json:"path"` // relative to the entry directory on the remote
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	SHA256  string      `json:"sha256"`
+}
+
+func newManifest() *Manifest {
+	hostname, _ := os.Hostname()
+	now := time.Now().UTC()
+	return &Manifest{
+		Format:    manifestFormat,
+		VyVersion: Version,
+		Hostname:  hostname,
+		CreatedAt: now,
+		Snapshot:  snapshotName(now),
+	}
+}
+
+// Find an entry by name, nil if the run did not include it
+func (m *Manifest) Entry(name string) *ManifestEntry {
+	for i := range m.Entries {
+		if m.Entries[i].Name == name {
+			return &m.Entries[i]
+		}
+	}
+	return nil
+}
+
+// Paths of the entry's files, relative to the entry directory
+func (e *ManifestEntry) fileList() []string {
+	files := make([]string, 0, len(e.Files))
+	for _, f := range e.Files {
+		files = append(files, f.Path)
+	}
+	return files
+}
+
+// Find a file by its path relative to the entry, nil if unknown
+func (e *ManifestEntry) File(path string) *ManifestFile {
+	for i := range e.Files {
+		if e.Files[i].Path == path {
+			return &e.Files[i]
+		}
+	}
+	return nil
+}
+
+// Hash every file of the entry the same way rclone lays it out on the remote:
+// a directory keeps its relative paths, a single file sits under its base name.
+// Hashes from previous are reused for files whose size and mtime did not change.
+func buildManifestEntry(target BackupEntry, previous *ManifestEntry) (ManifestEntry, error) {
+	path := target.Path
+	entry := ManifestEntry{Name: target.Remote, Source: path, Files: []ManifestFile{}}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return entry, err
+	}
+	root := path
+	entry.Type = entryTypeDir
+	if !info.IsDir() {
+		root = filepath.Dir(path)
+		entry.Type = entryTypeFile
+	}
+
+	filter := target.filter()
+	known := make(map[string]ManifestFile)
+	if previous != nil {
+		for _, file := range previous.Files {
+			known[file.Path] = file
+		}
+	}
+
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		// Ignored directories such as node_modules are not even walked
+		if info.IsDir() && filter.skipsDir(rel) {
+			return filepath.SkipDir
+		}
+		// rclone skips symlinks and special files by default, so leave them out too
+		if !info.Mode().IsRegular() || !filter.allows(rel) {
+			return nil
+		}
+
+		var sum string
+		if pf, ok := known[rel]; ok && pf.Size == info.Size() && pf.ModTime.Equal(info.ModTime()) {
+			sum = pf.SHA256
+		}
+		if sum == "" {
+			if sum, err = hashFile(file); err != nil {
+				return err
+			}
+		}
+
+		entry.Files = append(entry.Files, ManifestFile{
+			Path:    rel,
+			Size:    info.Size(),
+			Mode:    info.Mode().Perm(),
+			ModTime: info.ModTime().UTC(),
+			SHA256:  sum,
+		})
+		entry.FileCount++
+		entry.Size += info.Size()
+		return nil
+	})
+	return entry, err
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Write the manifest as <dir>/<fileName> so rclone uploads it under that name
+func writeManifest(m *Manifest, dir, fileName string) (string, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fileName)
+	return path, os.WriteFile(path, data, 0644)
+}
+
+// Upload the manifest into remoteDir
+func uploadManifest(store Storage, m *Manifest, remoteDir, fileName string) error {
+	tmpDir, err := os.MkdirTemp("", "vy-manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path, err := writeManifest(m, tmpDir, fileName)
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return store.Put(path, remoteDir, Filter{})
+}
+
+// Download and parse the manifest stored in remoteDir
+func fetchManifest(store Storage, remoteDir, fileName string) (*Manifest, error) {
+	tmpDir, err := os.MkdirTemp("", "vy-manifest-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := store.Get(remoteDir+"/"+fileName, tmpDir); err != nil {
+		return nil, err
+	}
+	return readManifest(filepath.Join(tmpDir, fileName))
+}
+
+// Read a manifest from a local file
+func readManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &m, nil
+}
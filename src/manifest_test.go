@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildManifestEntry(t *testing.T) {
+	home := t.TempDir()
+	writeFiles(t, home, map[string]string{
+		".bashrc":         "alias ll='ls -la'\n",
+		"nvim/init.lua":   "vim.o.number = true\n",
+		"nvim/lua/x.lua":  "return {}\n",
+		"nvim/debug.log":  "noise\n",
+		"ssh/config":      "Host *\n",
+		"ssh/known_hosts": "",
+	})
+
+	tests := []struct {
+		name   string
+		target BackupEntry
+		typ    string
+		files  []string
+	}{
+		{"single file", BackupEntry{Remote: "bash", Path: filepath.Join(home, ".bashrc")}, entryTypeFile, []string{".bashrc"}},
+		{"directory", BackupEntry{Remote: "ssh", Path: filepath.Join(home, "ssh")}, entryTypeDir, []string{"config", "known_hosts"}},
+		{
+			"filtered directory",
+			BackupEntry{Remote: "nvim", Path: filepath.Join(home, "nvim"), Exclude: []string{"*.log"}},
+			entryTypeDir,
+			[]string{"init.lua", "lua/x.lua"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := buildManifestEntry(tt.target, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Type != tt.typ || entry.Name != tt.target.Remote || entry.Source != tt.target.Path {
+				t.Errorf("entry = %s %s from %s, want %s %s from %s", entry.Type, entry.Name, entry.Source, tt.typ, tt.target.Remote, tt.target.Path)
+			}
+			if files := entry.fileList(); !equalStrings(files, tt.files) || entry.FileCount != len(tt.files) {
+				t.Errorf("files = %v (%d), want %v", files, entry.FileCount, tt.files)
+			}
+			for _, f := range entry.Files {
+				sum, _ := hashFile(filepath.Join(entryRoot(tt.target.Path), filepath.FromSlash(f.Path)))
+				if f.SHA256 != sum {
+					t.Errorf("%s: hash %s, want %s", f.Path, f.SHA256, sum)
+				}
+			}
+		})
+	}
+
+	if _, err := buildManifestEntry(BackupEntry{Remote: "gone", Path: filepath.Join(home, "gone")}, nil); !os.IsNotExist(err) {
+		t.Errorf("missing path: error = %v, want not exist", err)
+	}
+}
+
+func TestBuildManifestEntryReusesHashes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"same": "a", "touched": "b"})
+	target := BackupEntry{Remote: "dir", Path: dir}
+	first, err := buildManifestEntry(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A hash is only trusted while size and mtime match
+	for i := range first.Files {
+		first.Files[i].SHA256 = "previous"
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "touched"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	second, err := buildManifestEntry(target, &first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := second.File("same"); f == nil || f.SHA256 != "previous" {
+		t.Errorf("unchanged file was hashed again: %+v", f)
+	}
+	if f := second.File("touched"); f == nil || f.SHA256 == "previous" {
+		t.Errorf("touched file kept its old hash: %+v", f)
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"ssh/config": "Host *\n", ".bashrc": "x\n"})
+	ssh, err := buildManifestEntry(BackupEntry{Remote: "ssh", Path: filepath.Join(dir, "ssh")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bash, err := buildManifestEntry(BackupEntry{Remote: "bash", Path: filepath.Join(dir, ".bashrc")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bash.StoredIn = "2024-01-01T00-00-00Z"
+
+	m := newManifest()
+	m.Entries = []ManifestEntry{
+		ssh,
+		bash,
+		{Name: "gpg", Files: []ManifestFile{}, Error: "permission denied"},
+		{Name: "keys", Type: entryTypeDir, Files: []ManifestFile{}, Encryption: &ManifestEncryption{
+			Scheme: "age", Method: encryptX25519, Recipients: []string{"age1x"}, Object: "keys.tar.age", SHA256: "abc",
+		}},
+	}
+
+	store := newTestStorage(t)
+	if err := uploadManifest(store, m, "snap", manifestName); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fetchManifest(store, "snap", manifestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("fetched manifest differs:\n%+v\nwant\n%+v", got, m)
+	}
+	if e := got.Entry("bash"); e == nil || e.StoredIn != bash.StoredIn || got.Entry("missing") != nil {
+		t.Errorf("Entry lookup failed: %+v", e)
+	}
+
+	if _, err := fetchManifest(store, "nowhere", manifestName); err == nil {
+		t.Errorf("fetching a missing manifest succeeded")
+	}
+	invalid := filepath.Join(dir, "invalid.json")
+	writeFiles(t, dir, map[string]string{"invalid.json": "{"})
+	if _, err := readManifest(invalid); err == nil {
+		t.Errorf("reading an invalid manifest succeeded")
+	}
+}
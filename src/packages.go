@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/vaibhavyadav-dev/vy-cli/src/sysconfig"
+)
+
+// File the package inventory of an entry is written to
+const packagesFile = "packages.json"
+
+// Record the installed packages of the given managers in dir/packages.json
+func dumpPackages(managers []string, dir string) error {
+	inventory, err := sysconfig.CaptureInventory(managers)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(inventory, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeDump(dir, packagesFile, data)
+}
+
+// Read an inventory and find what is missing on this machine
+func missingPackages(file string) (sysconfig.Inventory, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var inventory sysconfig.Inventory
+	if err := json.Unmarshal(data, &inventory); err != nil {
+		return nil, fmt.Errorf("invalid package inventory: %w", err)
+	}
+	return sysconfig.MissingPackages(inventory)
+}
+
+// Install the packages of the inventory that are missing, unless restoring in skip mode
+func restorePackages(file, mode string) (string, error) {
+	if mode == RestoreSkip {
+		return "skip", nil
+	}
+
+	missing, err := missingPackages(file)
+	if err != nil {
+		return "", err
+	}
+	if len(missing) == 0 {
+		return "unchanged", nil
+	}
+
+	if err := sysconfig.InstallPackages(missing); err != nil {
+		return "", err
+	}
+	return "install", nil
+}
+
+// Print what restorePackages would install
+func printMissingPackages(missing sysconfig.Inventory) {
+	if len(missing) == 0 {
+		fmt.Printf("    %-10s every package is installed\n", "unchanged")
+		return
+	}
+
+	managers := make([]string, 0, len(missing))
+	for name := range missing {
+		managers = append(managers, name)
+	}
+	sort.Strings(managers)
+	for _, name := range managers {
+		var packages []string
+		for _, p := range missing[name] {
+			packages = append(packages, p.Name)
+		}
+		fmt.Printf("    %-10s %s: %s\n", "install", name, strings.Join(packages, " "))
+	}
+}
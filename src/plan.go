@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// One row of the table printed by vy backup --dry-run
+type planRow struct {
+	Name   string
+	Local  string
+	Remote string
+	Files  int
+	Size   int64
+	Skip   string // why the entry would not be uploaded, empty when it would be
+}
+
+// Where a path ends up on a destination, for messages
+func remoteLocation(drive, path string) string {
+	if strings.HasSuffix(drive, ":") || strings.HasSuffix(drive, "/") {
+		return drive + path
+	}
+	return drive + "/" + path
+}
+
+// Human readable size, e.g. 1.5 MB
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGT"[exp])
+}
+
+// Work out what vy backup would upload, without contacting the drive
+func planBackup(out io.Writer, opts BackupOptions, config *BackupConfig, entries []BackupEntry) error {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("Error getting home directory: %w", err)
+	}
+
+	// Only the local state is used, so an entry whose snapshot was removed by hand may show as unchanged
+	state := loadBackupState(homeDir)
+	dumpGenerated(out, entries, opts.Verbose)
+
+	host, err := currentHost(config.Host)
+	if err != nil {
+		return err
+	}
+	snapshot := snapshotName(time.Now())
+	snapshotDir := hostRoot(host) + "/" + snapshot
+
+	var rows []planRow
+	for _, target := range entries {
+		row := planRow{Name: target.Name, Local: target.Path, Remote: remoteLocation(opts.Drive, snapshotDir+"/"+target.Remote+"/")}
+		switch {
+		case opts.Archive != "":
+			row.Remote = remoteLocation(opts.Drive, snapshotDir+"/"+archiveName(opts.Archive))
+		case target.encrypted():
+			row.Remote += target.Remote + encryptedSuffix
+		}
+
+		if _, err := os.Stat(target.Path); err != nil {
+			row.Skip = "missing"
+			if !target.Optional {
+				row.Skip = "missing, would fail"
+			}
+			rows = append(rows, row)
+			continue
+		}
+
+		previous := state.lookup(opts.Drive, target.Remote)
+		var previousEntry *ManifestEntry
+		if previous != nil {
+			previousEntry = &previous.Entry
+		}
+		entry, err := buildManifestEntry(target, previousEntry)
+		if err != nil {
+			row.Skip = "unreadable: " + err.Error()
+			rows = append(rows, row)
+			continue
+		}
+		row.Files, row.Size = entry.FileCount, entry.Size
+
+		if !opts.Full && opts.Archive == "" && previous != nil && previous.unchanged(entry, config.Encryption.keysFor(target)) {
+			row.Skip = "unchanged since " + previous.Snapshot
+		}
+		rows = append(rows, row)
+	}
+
+	fmt.Fprintf(out, "Dry run: nothing will be uploaded. Backup plan for snapshot %s\n\n", snapshot)
+	printPlan(out, rows)
+	if opts.Verbose {
+		printIgnored(out, entries)
+	}
+	hooks, err := config.profileHooks(opts.Profile)
+	if err != nil {
+		return err
+	}
+	printHookPlan(out, hooks, entries)
+
+	// Secrets are looked for the same way, the policy only applies to a real backup
+	allowlist := secretsAllowlistPath(homeDir, config.Secrets)
+	allow, err := loadSecretsAllowlist(allowlist)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %w", allowlist, err)
+	}
+	policy := opts.Secrets
+	if policy == "" {
+		policy = config.Secrets.Policy
+	}
+	found := scanEntries(entries, allow, policy == secretsBlock, func(target BackupEntry) (ManifestEntry, error) {
+		return buildManifestEntry(target, nil)
+	})
+	if len(found) > 0 {
+		fmt.Fprintln(out)
+		printFindings(out, orderedFindings(entries, found), allowlist)
+	}
+	return nil
+}
+
+// Work out what vy backup -f would upload, without contacting the drive
+func planFolder(out io.Writer, folder, drive string, verbose bool) {
+	folderName := filepath.Base(folder)
+	row := planRow{Name: folderName, Local: folder, Remote: remoteLocation(drive, "Backups/"+folderName+"/")}
+
+	target, err := folderEntry(folder)
+	var entry ManifestEntry
+	if err == nil {
+		entry, err = buildManifestEntry(target, nil)
+	}
+	if err != nil {
+		row.Skip = "unreadable: " + err.Error()
+	}
+	row.Files, row.Size = entry.FileCount, entry.Size
+
+	fmt.Fprintf(out, "Dry run: nothing will be uploaded. Backup plan for folder %s\n\n", folder)
+	printPlan(out, []planRow{row})
+	if verbose {
+		printIgnored(out, []BackupEntry{target})
+	}
+}
+
+// Paths of the entry left out by the ignore list and .vyignore files, an ignored
+// directory is listed once with a trailing slash
+func ignoredPaths(target BackupEntry) []string {
+	filter := target.filter()
+	var ignored []string
+	filepath.Walk(target.Path, func(file string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(target.Path, file)
+		if err != nil || rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() && filter.skipsDir(rel) {
+			ignored = append(ignored, rel+"/")
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && filter.ignore.ignored(rel, false) {
+			ignored = append(ignored, rel)
+		}
+		return nil
+	})
+	return ignored
+}
+
+func printIgnored(out io.Writer, entries []BackupEntry) {
+	for _, target := range entries {
+		ignored := ignoredPaths(target)
+		if len(ignored) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\nIgnored in %s:\n", target.Name)
+		for _, path := range ignored {
+			fmt.Fprintf(out, "  %s\n", path)
+		}
+	}
+}
+
+func printPlan(out io.Writer, rows []planRow) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tLOCAL PATH\tREMOTE PATH\tFILES\tSIZE\tSKIP")
+
+	uploads, files, size := 0, 0, int64(0)
+	for _, row := range rows {
+		skip := row.Skip
+		if skip == "" {
+			skip = "-"
+			uploads++
+			files += row.Files
+			size += row.Size
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", row.Name, row.Local, row.Remote, row.Files, formatSize(row.Size), skip)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\n%d of %d configurations would be uploaded: %d files, %s\n", uploads, len(rows), files, formatSize(size))
+}
@@ -0,0 +1,359 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/vaibhavyadav-dev/vy-cli/src/sysconfig"
+)
+
+// Name of the built-in profile made from backupTargets
+const defaultProfileName = "default"
+
+// One thing to back up, as declared in a profile
+type BackupEntry struct {
+	Name     string   `toml:"name"`
+	Path     string   `toml:"path"`     // ~ is expanded, relative paths are taken from the home directory
+	Remote   string   `toml:"remote"`   // directory name on the remote, defaults to Name
+	Include  []string `toml:"include"`  // only back up files matching these globs
+	Exclude  []string `toml:"exclude"`  // never back up files matching these globs
+	Optional bool     `toml:"optional"` // missing paths are skipped silently instead of failing
+	Encrypt  *bool    `toml:"encrypt"`  // encrypt before upload, on by default for ssh
+	Dconf    []string `toml:"dconf"`    // dconf paths to dump instead of copying files, e.g. "/org/gnome/desktop/"
+	Packages []string `toml:"packages"` // package managers to record instead of copying files, e.g. "apt"
+	Export   string   `toml:"export"`   // exporter whose output is backed up instead of files, e.g. "crontab"
+	Hooks    Hooks    `toml:"hooks"`    // commands run before and after this entry is backed up
+
+	ignore []string // global ignore list of the config
+}
+
+type Profile struct {
+	Extends string        `toml:"extends"` // profile to start from, e.g. "default"
+	Skip    []string      `toml:"skip"`    // entries of the extended profile to leave out
+	Hooks   Hooks         `toml:"hooks"`   // run after those of the extended profile
+	Entries []BackupEntry `toml:"entries"`
+}
+
+// Contents of ~/.config/vy/backup.toml
+//
+//	default_profile = "laptop"
+//
+//	[profiles.laptop]
+//	extends = "default"
+//	skip = ["themes", "icons"]
+//
+//	[[profiles.laptop.entries]]
+//	name = "alacritty"
+//	path = "~/.config/alacritty"
+//	exclude = ["*.log"]
+type BackupConfig struct {
+	DefaultProfile string             `toml:"default_profile"`
+	Destinations   []string           `toml:"destinations"` // drives to back up to when -d is not given
+	Encryption     EncryptionConfig   `toml:"encryption"`
+	Secrets        SecretsConfig      `toml:"secrets"`
+	Transfer       TransferConfig     `toml:"transfer"`
+	Host           HostConfig         `toml:"host"`
+	Ignore         []string           `toml:"ignore"` // gitignore-style patterns left out of every entry, e.g. "node_modules/"
+	Profiles       map[string]Profile `toml:"profiles"`
+}
+
+// Where the backup config lives, honouring XDG_CONFIG_HOME
+func backupConfigPath(homeDir string) string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "vy", "backup.toml")
+	}
+	return filepath.Join(homeDir, ".config", "vy", "backup.toml")
+}
+
+// Read the backup config, a missing file is the same as an empty one
+func loadBackupConfig(homeDir string) (*BackupConfig, error) {
+	config := &BackupConfig{}
+	path := backupConfigPath(homeDir)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := toml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Entries holding private keys, encrypted unless the profile says otherwise
+var encryptedByDefault = map[string]bool{"ssh": true}
+
+// The built-in profile, every entry is optional since few machines have all of them
+func builtinEntries(homeDir string) []BackupEntry {
+	targets := backupTargets(homeDir)
+	entries := make([]BackupEntry, 0, len(targets))
+	for name, path := range targets {
+		entries = append(entries, BackupEntry{Name: name, Path: path, Optional: true})
+	}
+	// GNOME settings, as a readable dump rather than the binary database
+	entries = append(entries, BackupEntry{Name: "dconf", Dconf: []string{"/"}, Optional: true})
+	// Installed packages, so restore can bring back the apps that read the settings
+	entries = append(entries, BackupEntry{Name: "packages", Packages: sysconfig.PackageManagers(), Optional: true})
+	// Settings only a command can list, e.g. VS Code extensions
+	for _, exporter := range exporters {
+		entries = append(entries, BackupEntry{Name: exporter.Name(), Export: exporter.Name(), Optional: true})
+	}
+	sortEntries(entries)
+	return entries
+}
+
+// Resolve the entries of a profile, falling back to the config's default profile
+// and then the built-in one. Paths are expanded and remote names filled in.
+func loadProfile(name string) (*BackupConfig, []BackupEntry, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting home directory: %w", err)
+	}
+
+	config, err := loadBackupConfig(homeDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := config.profileEntries(name, homeDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, entries, nil
+}
+
+// Entries of a profile in a config already loaded, see loadProfile
+func (c *BackupConfig) profileEntries(name, homeDir string) ([]BackupEntry, error) {
+	name = c.profileName(name)
+	entries, err := c.resolve(name, homeDir, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Remote == "" {
+			entry.Remote = entry.Name
+		}
+		if entry.generated() {
+			// Dumped here before every backup, vy owns the directory so a failed dump can clear it
+			if entry.Path != "" {
+				return nil, fmt.Errorf("profile %s: entry %s is dumped by vy and can't have a path", name, entry.Name)
+			}
+			entry.Path = dumpDir(homeDir, entry.Remote)
+		} else {
+			entry.Path = expandHome(entry.Path, homeDir)
+		}
+		entry.ignore = c.Ignore
+		if entry.Encrypt == nil && encryptedByDefault[entry.Name] {
+			encrypt := true
+			entry.Encrypt = &encrypt
+		}
+
+		if err := validateEntry(entry); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+		if seen[entry.Remote] {
+			return nil, fmt.Errorf("profile %s: remote name %s is used twice", name, entry.Remote)
+		}
+		seen[entry.Remote] = true
+	}
+	return entries, nil
+}
+
+// Profile to use when name is empty: the config's default one, else the built-in one
+func (c *BackupConfig) profileName(name string) string {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		name = defaultProfileName
+	}
+	return name
+}
+
+// Hooks of the whole run for a profile, the extended profile's first
+func (c *BackupConfig) profileHooks(name string) (Hooks, error) {
+	var hooks Hooks
+	visiting := make(map[string]bool)
+	for name = c.profileName(name); name != ""; {
+		profile, ok := c.Profiles[name]
+		if !ok || visiting[name] {
+			break
+		}
+		visiting[name] = true
+		if err := validateHooks(profile.Hooks, false); err != nil {
+			return hooks, fmt.Errorf("profile %s: %w", name, err)
+		}
+		hooks = Hooks{
+			Pre:       append(append([]Hook{}, profile.Hooks.Pre...), hooks.Pre...),
+			Post:      append(append([]Hook{}, profile.Hooks.Post...), hooks.Post...),
+			OnFailure: append(append([]Hook{}, profile.Hooks.OnFailure...), hooks.OnFailure...),
+		}
+		name = profile.Extends
+	}
+	return hooks, nil
+}
+
+func (c *BackupConfig) resolve(name, homeDir string, visiting map[string]bool) ([]BackupEntry, error) {
+	profile, ok := c.Profiles[name]
+	if !ok {
+		if name == defaultProfileName {
+			return builtinEntries(homeDir), nil
+		}
+		return nil, fmt.Errorf("unknown profile: %s", name)
+	}
+
+	if visiting[name] {
+		return nil, fmt.Errorf("profile %s extends itself", name)
+	}
+	visiting[name] = true
+
+	var entries []BackupEntry
+	if profile.Extends != "" {
+		// A user profile named "default" may still extend the built-in one
+		var err error
+		if profile.Extends == name && name == defaultProfileName {
+			entries = builtinEntries(homeDir)
+		} else if entries, err = c.resolve(profile.Extends, homeDir, visiting); err != nil {
+			return nil, err
+		}
+	}
+
+	skip := make(map[string]bool)
+	for _, s := range profile.Skip {
+		skip[s] = true
+	}
+	// Entries declared here replace inherited ones with the same name
+	for _, entry := range profile.Entries {
+		skip[entry.Name] = true
+	}
+
+	merged := make([]BackupEntry, 0, len(entries)+len(profile.Entries))
+	for _, entry := range entries {
+		if !skip[entry.Name] {
+			merged = append(merged, entry)
+		}
+	}
+	merged = append(merged, profile.Entries...)
+	sortEntries(merged)
+	return merged, nil
+}
+
+// Entries made from command output, dumped to the cache before every backup
+func (e *BackupEntry) generated() bool {
+	return len(e.Dconf) > 0 || len(e.Packages) > 0 || e.Export != ""
+}
+
+// File a generated entry is dumped to inside its path
+func (e *BackupEntry) dumpFile() string {
+	switch {
+	case len(e.Packages) > 0:
+		return packagesFile
+	case e.Export != "":
+		return exportFile(e.Export)
+	default:
+		return dconfFile
+	}
+}
+
+func (e *BackupEntry) encrypted() bool {
+	return e.Encrypt != nil && *e.Encrypt
+}
+
+func validateEntry(entry *BackupEntry) error {
+	if entry.Name == "" {
+		return fmt.Errorf("entry without a name")
+	}
+	if entry.Path == "" {
+		return fmt.Errorf("entry %s has no path", entry.Name)
+	}
+	for _, path := range entry.Dconf {
+		if !strings.HasPrefix(path, "/") || !strings.HasSuffix(path, "/") {
+			return fmt.Errorf("entry %s has an invalid dconf path: %s (use e.g. /org/gnome/desktop/)", entry.Name, path)
+		}
+	}
+	known := make(map[string]bool)
+	for _, manager := range sysconfig.PackageManagers() {
+		known[manager] = true
+	}
+	for _, manager := range entry.Packages {
+		if !known[manager] {
+			return fmt.Errorf("entry %s has an unknown package manager: %s (use %s)",
+				entry.Name, manager, strings.Join(sysconfig.PackageManagers(), ", "))
+		}
+	}
+	if entry.Export != "" {
+		if _, err := findExporter(entry.Export); err != nil {
+			return fmt.Errorf("entry %s: %w", entry.Name, err)
+		}
+	}
+	kinds := 0
+	for _, set := range []bool{len(entry.Dconf) > 0, len(entry.Packages) > 0, entry.Export != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		return fmt.Errorf("entry %s can only have one of dconf, packages and export", entry.Name)
+	}
+	if err := validateHooks(entry.Hooks, true); err != nil {
+		return fmt.Errorf("entry %s: %w", entry.Name, err)
+	}
+	if strings.ContainsAny(entry.Remote, `/\`) || entry.Remote == "." || entry.Remote == ".." {
+		return fmt.Errorf("entry %s has an invalid remote name: %s", entry.Name, entry.Remote)
+	}
+	return nil
+}
+
+func sortEntries(entries []BackupEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+}
+
+func expandHome(path, homeDir string) string {
+	switch {
+	case path == "~":
+		return homeDir
+	case strings.HasPrefix(path, "~/"):
+		return filepath.Join(homeDir, path[2:])
+	case !filepath.IsAbs(path):
+		return filepath.Join(homeDir, path)
+	}
+	return path
+}
+
+// Files of the entry left out of the upload. A directory's .vyignore files are
+// read along with the global ignore list, a single file only goes by the list.
+func (e *BackupEntry) filter() Filter {
+	root := ""
+	if info, err := os.Stat(e.Path); err == nil && info.IsDir() {
+		root = e.Path
+	}
+	return Filter{Include: e.Include, Exclude: e.Exclude, ignore: newIgnoreMatcher(root, e.ignore)}
+}
+
+// Entry for a folder uploaded with -f, which goes by the global ignore list and its .vyignore files
+func folderEntry(folder string) (BackupEntry, error) {
+	name := filepath.Base(folder)
+	entry := BackupEntry{Name: name, Path: folder, Remote: name}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return entry, fmt.Errorf("Error getting home directory: %w", err)
+	}
+	config, err := loadBackupConfig(homeDir)
+	if err != nil {
+		return entry, err
+	}
+	entry.ignore = config.Ignore
+	return entry, nil
+}
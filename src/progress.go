@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often progress is shown: a bar on a terminal is redrawn, logs and pipes get a line
+const (
+	progressRedraw   = 200 * time.Millisecond
+	progressInterval = 10 * time.Second
+	progressBarWidth = 30
+)
+
+// Bytes uploaded by a backup run, shown as a bar on a terminal and as periodic lines otherwise.
+// A nil progress shows nothing, so callers don't have to check.
+type progress struct {
+	out     io.Writer
+	tty     bool
+	started time.Time
+
+	mu     sync.Mutex
+	total  int64
+	done   int64            // bytes of finished uploads
+	active map[string]int64 // bytes so far of uploads in progress, by name
+	names  []string         // of active, in the order they started
+	drawn  bool             // the bar is on screen
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// Start showing the progress of uploading total bytes on out, a bar when it is a terminal
+func newProgress(out io.Writer, total int64) *progress {
+	f, ok := out.(*os.File)
+	p := &progress{
+		out:     out,
+		tty:     ok && isTerminal(f),
+		started: time.Now(),
+		total:   total,
+		active:  make(map[string]int64),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progress) loop() {
+	defer close(p.stopped)
+	interval := progressInterval
+	if p.tty {
+		interval = progressRedraw
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.show()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Start uploading name, the returned func takes the bytes uploaded so far
+func (p *progress) entry(name string) func(bytes int64) {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	p.active[name] = 0
+	p.names = append(p.names, name)
+	p.mu.Unlock()
+	return func(bytes int64) {
+		p.mu.Lock()
+		if _, ok := p.active[name]; ok {
+			p.active[name] = bytes
+		}
+		p.mu.Unlock()
+	}
+}
+
+// Done with name, whatever was reported for it counts as size bytes
+func (p *progress) finish(name string, size int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.active[name]; !ok {
+		return
+	}
+	delete(p.active, name)
+	for i, n := range p.names {
+		if n == name {
+			p.names = append(p.names[:i], p.names[i+1:]...)
+			break
+		}
+	}
+	p.done += size
+}
+
+// Print s above the bar, unlike the other methods it needs a progress
+func (p *progress) print(s string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Fprint(p.out, s)
+}
+
+// Print b above the bar, so retries can be announced while it is shown
+func (p *progress) Write(b []byte) (int, error) {
+	p.print(string(b))
+	return len(b), nil
+}
+
+// Stop showing progress and take the bar off the screen
+func (p *progress) close() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+	p.mu.Lock()
+	p.clear()
+	p.mu.Unlock()
+}
+
+func (p *progress) clear() {
+	if p.drawn {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+func (p *progress) show() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.active) == 0 {
+		return
+	}
+
+	bytes := p.done
+	for _, n := range p.active {
+		bytes += n
+	}
+	if bytes > p.total {
+		bytes = p.total
+	}
+	elapsed := time.Since(p.started)
+	rate := int64(float64(bytes) / elapsed.Seconds())
+	eta := "-"
+	if rate > 0 {
+		eta = (time.Duration(float64(p.total-bytes)/float64(rate)) * time.Second).Round(time.Second).String()
+	}
+	percent := 100
+	if p.total > 0 {
+		percent = int(bytes * 100 / p.total)
+	}
+	current := p.names[0]
+	if len(p.names) > 1 {
+		current = fmt.Sprintf("%s +%d", current, len(p.names)-1)
+	}
+
+	if !p.tty {
+		fmt.Fprintf(p.out, "Uploaded %s of %s (%d%%), %s/s, ETA %s, now %s\n",
+			formatSize(bytes), formatSize(p.total), percent, formatSize(rate), eta, current)
+		return
+	}
+	filled := percent * progressBarWidth / 100
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	fmt.Fprintf(p.out, "\r\033[K[%s] %3d%%  %s of %s  %s/s  ETA %s  %s",
+		bar, percent, formatSize(bytes), formatSize(p.total), formatSize(rate), eta, current)
+	p.drawn = true
+}
+
+// Writer that reports the bytes written through it so far
+type countingWriter struct {
+	w      io.Writer
+	n      int64
+	report func(bytes int64)
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	c.report(c.n)
+	return n, err
+}
@@ -0,0 +1,313 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage on any rclone remote, e.g. gdrive: or onedrive:Backups
+type rcloneStorage struct {
+	remote string
+	ctx    context.Context // kills rclone when the run times out
+	flags  []string        // added to every command, e.g. --bwlimit
+}
+
+func newRcloneStorage(remote string, t *transfer) (*rcloneStorage, error) {
+	if err := checkRcloneInstallation(remote); err != nil {
+		return nil, err
+	}
+	return &rcloneStorage{remote: remote, ctx: t.ctx, flags: t.rcloneFlags()}, nil
+}
+
+// A failed rclone command, with its exit code
+type rcloneError struct {
+	code   int
+	err    error
+	stdout string
+	stderr string
+}
+
+func (e *rcloneError) Error() string {
+	return fmt.Sprintf("rclone error: %v\nOutput: %s\nError: %s", e.err, e.stdout, e.stderr)
+}
+
+func (e *rcloneError) Unwrap() error {
+	return e.err
+}
+
+// Only rclone's exit codes 2 (uncategorised error) and 5 (temporary error) are worth another
+// try. 1 is bad flags, 3 and 4 a missing directory or file, 6 a NoRetry error, 7 fatal,
+// 8 a transfer limit, 9 nothing transferred and 10 the duration limit.
+// See https://rclone.org/docs/#exit-code
+func (e *rcloneError) Temporary() bool {
+	return e.code == 2 || e.code == 5
+}
+
+func (s *rcloneStorage) String() string {
+	return s.remote
+}
+
+// Full rclone path of a path relative to the remote
+func (s *rcloneStorage) path(remotePath string) string {
+	if strings.HasSuffix(s.remote, ":") {
+		return s.remote + remotePath
+	}
+	return s.remote + "/" + remotePath
+}
+
+// Run rclone and return its output, missing paths are reported as os.ErrNotExist
+func (s *rcloneStorage) run(args ...string) ([]byte, error) {
+	return s.runStats(nil, args...)
+}
+
+// Run rclone, passing the bytes transferred so far to progress every second when it is set
+func (s *rcloneStorage) runStats(progress func(bytes int64), args ...string) ([]byte, error) {
+	path := args[len(args)-1]
+	args = append(args, s.flags...)
+	var stdout, stderr bytes.Buffer
+	var logs *statsWriter
+	if progress != nil {
+		args = append(args, "--use-json-log", "--stats", "1s", "--stats-log-level", "NOTICE")
+		logs = &statsWriter{stderr: &stderr, progress: progress}
+	}
+	cmd := exec.CommandContext(s.ctx, "rclone", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if logs != nil {
+		cmd.Stderr = logs
+	}
+
+	err := cmd.Run()
+	if logs != nil {
+		logs.flush()
+	}
+	if err != nil {
+		if s.ctx.Err() != nil {
+			return nil, fmt.Errorf("backup timed out: %w", s.ctx.Err())
+		}
+		// rclone exits with 3 for a missing directory and 4 for a missing file
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && (exitErr.ExitCode() == 3 || exitErr.ExitCode() == 4) {
+			return nil, notFound(path)
+		}
+		code := -1
+		if exitErr != nil {
+			code = exitErr.ExitCode()
+		}
+		return nil, &rcloneError{code: code, err: err, stdout: stdout.String(), stderr: stderr.String()}
+	}
+	return stdout.Bytes(), nil
+}
+
+func (s *rcloneStorage) Put(localPath, remotePath string, filter Filter) error {
+	return s.PutProgress(localPath, remotePath, filter, nil)
+}
+
+func (s *rcloneStorage) PutProgress(localPath, remotePath string, filter Filter, progress func(bytes int64)) error {
+	args := []string{"copy"}
+	// rclone gets the files the filter lets through rather than rules of its own,
+	// so it uploads exactly what the manifest lists
+	if !filter.empty() {
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !filter.allows(filepath.Base(localPath)) {
+			return nil
+		}
+		if info.IsDir() {
+			list, err := writeFileList(filter, localPath)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(list)
+			args = append(args, "--files-from-raw", list)
+		}
+	}
+	_, err := s.runStats(progress, append(args, localPath, s.path(remotePath))...)
+	return err
+}
+
+// Write the files of dir the filter lets through to a temporary file for --files-from-raw
+func writeFileList(filter Filter, dir string) (string, error) {
+	files, err := filter.files(dir)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "vy-files-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	for _, file := range files {
+		if _, err := fmt.Fprintln(f, file); err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+	}
+	return f.Name(), nil
+}
+
+// Line of rclone --use-json-log output, stats lines have the transfer so far
+type rcloneLog struct {
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+	Stats *struct {
+		Bytes int64 `json:"bytes"`
+	} `json:"stats"`
+}
+
+// Takes rclone's JSON log: stats go to progress, anything else to stderr for error messages
+type statsWriter struct {
+	stderr   *bytes.Buffer
+	progress func(bytes int64)
+	partial  []byte
+}
+
+func (w *statsWriter) Write(b []byte) (int, error) {
+	w.partial = append(w.partial, b...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		w.line(w.partial[:i])
+		w.partial = w.partial[i+1:]
+	}
+}
+
+func (w *statsWriter) flush() {
+	if len(w.partial) > 0 {
+		w.line(w.partial)
+		w.partial = nil
+	}
+}
+
+func (w *statsWriter) line(line []byte) {
+	var log rcloneLog
+	if err := json.Unmarshal(line, &log); err != nil {
+		w.stderr.Write(line)
+		w.stderr.WriteByte('\n')
+		return
+	}
+	if log.Stats != nil {
+		w.progress(log.Stats.Bytes)
+		return
+	}
+	fmt.Fprintf(w.stderr, "%s: %s\n", strings.ToUpper(log.Level), strings.TrimSpace(log.Msg))
+}
+
+func (s *rcloneStorage) Get(remotePath, localPath string) error {
+	_, err := s.run("copy", s.path(remotePath), localPath)
+	return err
+}
+
+// Entry of rclone lsjson output
+type rcloneFile struct {
+	Path    string    `json:"Path"`
+	Size    int64     `json:"Size"`
+	ModTime time.Time `json:"ModTime"`
+	IsDir   bool      `json:"IsDir"`
+}
+
+func (f rcloneFile) remoteFile() RemoteFile {
+	return RemoteFile{Path: f.Path, Size: f.Size, ModTime: f.ModTime, IsDir: f.IsDir}
+}
+
+func (s *rcloneStorage) List(remotePath string, recursive bool) ([]RemoteFile, error) {
+	args := []string{"lsjson"}
+	if recursive {
+		args = append(args, "-R")
+	}
+	output, err := s.run(append(args, s.path(remotePath))...)
+	if err != nil {
+		return nil, err
+	}
+
+	var listing []rcloneFile
+	if err := json.Unmarshal(output, &listing); err != nil {
+		return nil, fmt.Errorf("invalid rclone listing: %w", err)
+	}
+	files := make([]RemoteFile, 0, len(listing))
+	for _, f := range listing {
+		files = append(files, f.remoteFile())
+	}
+	return files, nil
+}
+
+func (s *rcloneStorage) Mkdir(remotePath string) error {
+	_, err := s.run("mkdir", s.path(remotePath))
+	return err
+}
+
+func (s *rcloneStorage) Stat(remotePath string) (RemoteFile, error) {
+	output, err := s.run("lsjson", "--stat", s.path(remotePath))
+	if err != nil {
+		return RemoteFile{}, err
+	}
+
+	var f rcloneFile
+	if err := json.Unmarshal(output, &f); err != nil {
+		return RemoteFile{}, fmt.Errorf("invalid rclone listing: %w", err)
+	}
+	return f.remoteFile(), nil
+}
+
+func (s *rcloneStorage) Delete(remotePath string) error {
+	info, err := s.Stat(remotePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		_, err = s.run("purge", s.path(remotePath))
+	} else {
+		_, err = s.run("deletefile", s.path(remotePath))
+	}
+	return err
+}
+
+func (s *rcloneStorage) Hashes(remotePath string) (map[string]string, error) {
+	output, err := s.run("hashsum", "sha256", s.path(remotePath))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// Not every remote stores SHA-256 (OneDrive doesn't), hash a download instead
+		output, err = s.run("hashsum", "sha256", "--download", s.path(remotePath))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		// <hash>  <path>
+		if sum, path, ok := strings.Cut(line, "  "); ok {
+			hashes[path] = sum
+		}
+	}
+	return hashes, nil
+}
+
+// Check if rclone is installed and configured with the specified drive
+func checkRcloneInstallation(drive string) error {
+	// Check if rclone is installed
+	_, err := exec.LookPath("rclone")
+	if err != nil {
+		return fmt.Errorf("rclone is not installed. Please install it using:\ncurl https://rclone.org/install.sh | sudo bash\nThen authenticate with: rclone config")
+	}
+
+	// Check if rclone is configured with drive or not
+	remote := drive[:strings.Index(drive, ":")+1]
+	cmd := exec.Command("rclone", "listremotes")
+	output, err := cmd.Output()
+	if err != nil || !bytes.Contains(output, []byte(remote)) {
+		return fmt.Errorf("rclone is not configured with %s.\nPlease run 'rclone config' and set up your OneDrive connection", remote)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Exit codes of vy backup, so wrapper scripts can tell what went wrong
+const (
+	ExitSuccess  = 0 // every entry was backed up
+	ExitFailed   = 1 // nothing was backed up
+	ExitPartial  = 2 // some entries failed
+	ExitNoRemote = 3 // rclone is missing, or the remote or backup directory is not set up
+)
+
+// Result of a backup run, printed with --json
+type BackupReport struct {
+	Status    string          `json:"status"` // success, partial, failed or no_remote
+	ExitCode  int             `json:"exit_code"`
+	Drive     string          `json:"drive,omitempty"`
+	Snapshot  string          `json:"snapshot,omitempty"`
+	StartedAt time.Time       `json:"started_at"`
+	Duration  float64         `json:"duration_seconds"`
+	Bytes     int64           `json:"bytes"` // size of the entries that were uploaded
+	Entries   []EntryReport   `json:"entries,omitempty"`
+	Error     string          `json:"error,omitempty"` // set when the run stopped before or after the entries
+	Secrets   []secretFinding `json:"secrets,omitempty"`
+	Hooks     []HookReport    `json:"hooks,omitempty"` // in the order they ran
+
+	// With several destinations, the report of each one, and the above only sums them up
+	Destinations []*BackupReport `json:"destinations,omitempty"`
+}
+
+type EntryReport struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"` // uploaded, unchanged, failed, missing or skipped
+	Files    int     `json:"files"`
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+var exitStatus = map[int]string{
+	ExitSuccess:  "success",
+	ExitFailed:   "failed",
+	ExitPartial:  "partial",
+	ExitNoRemote: "no_remote",
+}
+
+func newBackupReport(drive string) *BackupReport {
+	return &BackupReport{Drive: drive, StartedAt: time.Now().UTC(), Entries: []EntryReport{}}
+}
+
+func (r *BackupReport) add(result entryResult) {
+	entry := EntryReport{
+		Name:     result.entry.Name,
+		Status:   result.status,
+		Files:    result.entry.FileCount,
+		Bytes:    result.entry.Size,
+		Duration: result.duration.Seconds(),
+		Error:    result.entry.Error,
+	}
+	if result.status == entryUploaded {
+		r.Bytes += entry.Bytes
+	}
+	r.Entries = append(r.Entries, entry)
+}
+
+// Exit code for the entries of a run: a failure only when none of them made it
+func (r *BackupReport) exitCode() int {
+	failed := 0
+	for _, entry := range r.Entries {
+		if entry.Status == entryFailed || entry.Status == entryMissing || entry.Status == entrySkipped {
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		return ExitSuccess
+	case failed == len(r.Entries):
+		return ExitFailed
+	}
+	return ExitPartial
+}
+
+// Remote names of the entries that failed on any destination
+func (r *BackupReport) failedEntries() map[string]bool {
+	failed := make(map[string]bool)
+	for _, report := range append([]*BackupReport{r}, r.Destinations...) {
+		for _, entry := range report.Entries {
+			if entry.Status != entryUploaded && entry.Status != entryUnchanged {
+				failed[entry.Name] = true
+			}
+		}
+	}
+	return failed
+}
+
+func (r *BackupReport) finish(code int) {
+	r.ExitCode = code
+	r.Status = exitStatus[code]
+	r.Duration = time.Since(r.StartedAt).Seconds()
+}
+
+func (r *BackupReport) print() error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
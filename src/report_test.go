@@ -0,0 +1,45 @@
+package cmd
+
+import "testing"
+
+func TestReportExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		want     int
+	}{
+		{"no entries", nil, ExitSuccess},
+		{"uploaded", []string{entryUploaded, entryUnchanged}, ExitSuccess},
+		{"one failed", []string{entryUploaded, entryFailed}, ExitPartial},
+		{"one missing", []string{entryUnchanged, entryMissing}, ExitPartial},
+		{"one skipped by a hook", []string{entryUploaded, entrySkipped}, ExitPartial},
+		{"all failed", []string{entryFailed, entryMissing, entrySkipped}, ExitFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := newBackupReport("gdrive:")
+			for _, status := range tt.statuses {
+				report.add(entryResult{entry: ManifestEntry{Name: status, Size: 10}, status: status})
+			}
+			code := report.exitCode()
+			if code != tt.want {
+				t.Errorf("exitCode = %d, want %d", code, tt.want)
+			}
+			report.finish(code)
+			if report.ExitCode != code || report.Status != exitStatus[code] {
+				t.Errorf("finish(%d) = %d %s", code, report.ExitCode, report.Status)
+			}
+		})
+	}
+}
+
+func TestReportBytes(t *testing.T) {
+	report := newBackupReport("")
+	for _, status := range []string{entryUploaded, entryUnchanged, entryFailed, entryUploaded} {
+		report.add(entryResult{entry: ManifestEntry{Name: status, Size: 100}, status: status})
+	}
+	// Only what went over the network counts
+	if report.Bytes != 200 || len(report.Entries) != 4 {
+		t.Errorf("bytes %d, %d entries, want 200 and 4", report.Bytes, len(report.Entries))
+	}
+}
@@ -0,0 +1,514 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How restore treats a local file that already exists
+const (
+	RestoreOverwrite = "overwrite" // replace the local file
+	RestoreSkip      = "skip"      // keep the local file untouched
+	RestoreBackup    = "backup"    // rename the local file, then restore
+)
+
+type RestoreOptions struct {
+	Drive    string
+	Verbose  bool
+	DryRun   bool
+	Snapshot string   // snapshot to restore from, empty means the latest
+	Profile  string   // backup profile the entries come from, empty means the default
+	Mode     string   // one of RestoreOverwrite, RestoreSkip, RestoreBackup
+	Entries  []string // entry names from the profile, empty means all
+	Host     string   // machine whose backup to restore, empty means this one
+}
+
+// Pull Backups/ubuntu-settings/hosts/<host>/<snapshot>/<name> from the drive and put every entry back in place,
+// returning the exit code for vy
+func HandleRestore(opts RestoreOptions) int {
+	switch opts.Mode {
+	case RestoreOverwrite, RestoreSkip, RestoreBackup:
+	default:
+		fmt.Printf("Unknown restore mode: %s (use overwrite, skip or backup)\n", opts.Mode)
+		return ExitFailed
+	}
+
+	// Every local path is resolved from here, never from the working directory
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Printf("Error getting home directory: %v\n", err)
+		return ExitFailed
+	}
+
+	store, err := newStorage(opts.Drive)
+	if err != nil {
+		fmt.Println(err)
+		return ExitNoRemote
+	}
+
+	config, entries, err := loadProfile(opts.Profile)
+	if err != nil {
+		fmt.Println(err)
+		return ExitFailed
+	}
+
+	targets, err := selectEntries(withLegacyEntries(entries, homeDir), opts.Entries)
+	if err != nil {
+		fmt.Println(err)
+		return ExitFailed
+	}
+
+	// Another machine's backup is only restored when asked for by name
+	host, err := currentHost(config.Host)
+	if err != nil {
+		fmt.Println(err)
+		return ExitFailed
+	}
+	legacy := opts.Host == "" || opts.Host == host
+	if !legacy {
+		fmt.Printf("⚠️  Restoring the backup of host %s, not of this machine (%s)\n", opts.Host, host)
+		host = opts.Host
+	}
+	sourceDir, err := resolveSnapshotDir(store, host, opts.Snapshot, legacy)
+	if err != nil {
+		fmt.Println(err)
+		return ExitFailed
+	}
+
+	// Older backups have no manifest, restore still works but without file modes and times
+	manifest, err := fetchManifest(store, sourceDir, manifestName)
+	if err != nil && opts.Verbose {
+		fmt.Printf("No manifest found on %s, file modes will not be restored\n", opts.Drive)
+	}
+
+	stageDir, err := os.MkdirTemp("", "vy-restore-*")
+	if err != nil {
+		fmt.Printf("Error creating staging directory: %v\n", err)
+		return ExitFailed
+	}
+	defer os.RemoveAll(stageDir)
+
+	// Snapshots written with --archive hold every entry in a single object, which also
+	// carries the manifest in case the one beside it is gone
+	archive := ""
+	if manifest != nil {
+		archive = manifest.Archive
+	} else {
+		archive = findArchive(store, sourceDir)
+	}
+	unpacked := filepath.Join(stageDir, "archive")
+	if archive != "" {
+		if opts.Verbose {
+			fmt.Printf("📦 Downloading %s...\n", archive)
+		}
+		if err := fetchArchive(store, sourceDir, archive, unpacked); err != nil {
+			fmt.Printf("❌ Failed to download %s\n  Error: %v\n", archive, err)
+			return ExitFailed
+		}
+		if manifest == nil {
+			manifest, _ = readManifest(filepath.Join(unpacked, manifestName))
+		}
+	}
+
+	decrypt := &decryptor{config: config.Encryption, homeDir: homeDir}
+
+	// Suffix for local files moved aside in backup mode
+	stamp := time.Now().Format("20060102-150405")
+
+	if opts.DryRun {
+		fmt.Printf("Dry run: nothing will be written. Restoring %s from %s\n\n", sourceDir, opts.Drive)
+	} else {
+		fmt.Printf("Restoring %s from %s (mode: %s)\n\n", sourceDir, opts.Drive, opts.Mode)
+	}
+
+	restored, found := 0, 0
+	for _, target := range targets {
+		name := target.Name
+
+		var entry *ManifestEntry
+		if manifest != nil {
+			entry = manifest.Entry(target.Remote)
+		}
+
+		remoteDir := sourceDir + "/" + target.Remote
+		if entry != nil && entry.StoredIn != "" {
+			// Unchanged entries are kept in the snapshot that first uploaded them
+			remoteDir = storedInDir(sourceDir, entry.StoredIn) + "/" + target.Remote
+		}
+
+		// An encrypted or archived entry is not stored as loose files, they are listed in the manifest
+		encrypted := entry != nil && entry.Encryption != nil
+		var files []string
+		if archive != "" {
+			if entry != nil && entry.Error == "" {
+				files = entry.fileList()
+			}
+		} else if listed, err := listFiles(store, remoteDir); err == nil {
+			files = listed
+			if encrypted && len(files) > 0 {
+				files = entry.fileList()
+			}
+		}
+		if len(files) == 0 {
+			// Most machines only have some of the entries, so only mention the ones asked for
+			if opts.Verbose || len(opts.Entries) > 0 {
+				fmt.Printf("  ⏭️  %s: not found on %s\n", name, opts.Drive)
+			}
+			continue
+		}
+		found++
+
+		// Generated entries are loaded or installed, the dump in the cache is only used for backups
+		dest := target.Path
+		switch {
+		case len(target.Dconf) > 0:
+			dest = "dconf"
+		case len(target.Packages) > 0:
+			dest = "package managers"
+		case target.Export != "":
+			dest = target.Export
+		}
+		if opts.DryRun && len(target.Dconf) > 0 {
+			fmt.Printf("📥 %s -> %s\n", name, dest)
+			fmt.Printf("    %-10s %s\n", plannedDconf(opts.Mode), dconfFile)
+			continue
+		}
+		if opts.DryRun && len(target.Packages) == 0 && target.Export == "" {
+			fmt.Printf("📥 %s -> %s\n", name, dest)
+			for _, file := range files {
+				local := restorePath(dest, entry, files, file)
+				fmt.Printf("    %-10s %s\n", plannedAction(local, opts.Mode), local)
+			}
+			continue
+		}
+
+		if opts.Verbose && !opts.DryRun {
+			fmt.Printf("📥 Restoring %s to %s... ", name, dest)
+		}
+
+		staged := filepath.Join(stageDir, name)
+		if archive != "" {
+			staged = filepath.Join(unpacked, target.Remote)
+		} else if err := store.Get(remoteDir, staged); err != nil {
+			fmt.Printf("❌ Failed to restore %s\n  Error: %v\n\n", name, err)
+			continue
+		}
+
+		if encrypted {
+			plain := staged + ".plain"
+			if err := decrypt.decryptEntry(entry.Encryption, filepath.Join(staged, entry.Encryption.Object), plain); err != nil {
+				fmt.Printf("❌ Failed to decrypt %s\n  Error: %v\n\n", name, err)
+				continue
+			}
+			staged = plain
+		}
+
+		// What is missing can only be told from the inventory or export, so it is downloaded even in a dry run
+		if opts.DryRun && target.Export != "" {
+			fmt.Printf("📥 %s -> %s\n", name, dest)
+			printPendingExport(target.Export, filepath.Join(staged, exportFile(target.Export)), opts.Mode)
+			continue
+		}
+		if opts.DryRun {
+			fmt.Printf("📥 %s -> %s\n", name, dest)
+			if opts.Mode == RestoreSkip {
+				fmt.Printf("    %-10s %s\n", "skip", packagesFile)
+			} else if missing, err := missingPackages(filepath.Join(staged, packagesFile)); err != nil {
+				fmt.Printf("    ⚠️  %v\n", err)
+			} else {
+				printMissingPackages(missing)
+			}
+			continue
+		}
+
+		if target.generated() {
+			var action string
+			backupDir := restoreBackupDir(homeDir, stamp, target.Remote)
+			switch {
+			case len(target.Packages) > 0:
+				action, err = restorePackages(filepath.Join(staged, packagesFile), opts.Mode)
+			case target.Export != "":
+				action, err = restoreExport(target.Export, filepath.Join(staged, exportFile(target.Export)), backupDir, opts.Mode)
+			default:
+				action, err = restoreDconf(filepath.Join(staged, dconfFile), backupDir, opts.Mode)
+			}
+			if err != nil {
+				fmt.Printf("❌ Failed to restore %s\n  Error: %v\n\n", name, err)
+				continue
+			}
+			if opts.Verbose {
+				fmt.Printf("✅ Success (%s)\n", action)
+			}
+			if len(target.Packages) == 0 && opts.Mode == RestoreBackup {
+				fmt.Printf("💾 Previous settings of %s saved to %s\n", name, filepath.Join(backupDir, target.dumpFile()))
+			}
+			if opts.Verbose {
+				fmt.Println()
+			}
+			restored++
+			continue
+		}
+
+		counts := make(map[string]int)
+		failed := false
+		for _, file := range files {
+			local := restorePath(dest, entry, files, file)
+			action, err := restoreFile(filepath.Join(staged, file), local, opts.Mode, stamp)
+			if err != nil {
+				fmt.Printf("❌ Failed to restore %s\n  Error: %v\n\n", file, err)
+				failed = true
+				continue
+			}
+			counts[action]++
+
+			if entry == nil || action == "skip" || action == "unchanged" {
+				continue
+			}
+			if mf := entry.File(file); mf != nil {
+				if err := applyFileMetadata(local, mf); err != nil {
+					fmt.Printf("⚠️  Could not restore mode of %s: %v\n", local, err)
+				}
+			}
+		}
+
+		if name == "ssh" {
+			if err := fixSSHPermissions(dest); err != nil {
+				fmt.Printf("⚠️  Could not fix permissions on %s: %v\n", dest, err)
+			}
+		}
+
+		if failed {
+			continue
+		}
+		if opts.Verbose {
+			fmt.Printf("✅ Success (%s)\n\n", summarizeActions(counts))
+		}
+		restored++
+	}
+
+	if opts.DryRun {
+		return ExitSuccess
+	}
+	fmt.Printf("Restore completed! Successfully restored %d of %d configurations\n", restored, found)
+	switch {
+	case restored == found:
+		return ExitSuccess
+	case restored == 0:
+		return ExitFailed
+	}
+	return ExitPartial
+}
+
+// Pick the requested entries out of the profile, all of them when none are requested
+func selectEntries(entries []BackupEntry, requested []string) ([]BackupEntry, error) {
+	if len(requested) == 0 {
+		return entries, nil
+	}
+
+	selected := make([]BackupEntry, 0, len(requested))
+	for _, name := range requested {
+		found := false
+		for _, entry := range entries {
+			if entry.Name == name {
+				selected = append(selected, entry)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown entry: %s", name)
+		}
+	}
+	return selected, nil
+}
+
+// Entries older versions of the built-in profile had, by the remote name of the one that replaced them.
+// Snapshots taken back then still hold them, so restore puts them back as before.
+var legacyEntries = map[string]BackupEntry{
+	// The binary database, now backed up as a dump
+	"dconf": {Name: "dconf-settings", Path: "~/.config/dconf", Optional: true},
+}
+
+// Add the legacy entries of a profile, unless it has one of the same name
+func withLegacyEntries(entries []BackupEntry, homeDir string) []BackupEntry {
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		names[entry.Remote] = true
+	}
+	all := append([]BackupEntry{}, entries...)
+	for _, entry := range entries {
+		legacy, ok := legacyEntries[entry.Remote]
+		if !ok || names[legacy.Name] {
+			continue
+		}
+		legacy.Remote = legacy.Name
+		legacy.Path = expandHome(legacy.Path, homeDir)
+		all = append(all, legacy)
+		names[legacy.Name] = true
+	}
+	return all
+}
+
+// What restore would do with an entry's dconf settings in the given mode
+func plannedDconf(mode string) string {
+	if mode == RestoreSkip {
+		return "skip"
+	}
+	return "load"
+}
+
+// A single file like .bashrc is uploaded by rclone as <name>/.bashrc,
+// so it goes back to dest itself instead of into dest as a directory.
+// Manifests from before ManifestEntry.Type only tell it by the file name.
+func restorePath(dest string, entry *ManifestEntry, files []string, file string) string {
+	single := len(files) == 1 && files[0] == filepath.Base(dest)
+	if entry != nil && entry.Type != "" {
+		single = entry.Type == entryTypeFile
+	}
+	if single {
+		return dest
+	}
+	return filepath.Join(dest, filepath.FromSlash(file))
+}
+
+// What restoreFile would do with local, without touching it
+func plannedAction(local, mode string) string {
+	if _, err := os.Lstat(local); err != nil {
+		return "create"
+	}
+	switch mode {
+	case RestoreSkip:
+		return "skip"
+	case RestoreBackup:
+		return "backup"
+	}
+	return "overwrite"
+}
+
+// Copy a staged file over local according to mode, returning the action taken
+func restoreFile(staged, local, mode, stamp string) (string, error) {
+	action := plannedAction(local, mode)
+	if action != "create" && sameContent(staged, local) {
+		return "unchanged", nil
+	}
+
+	switch action {
+	case "skip":
+		return action, nil
+	case "backup":
+		if err := os.Rename(local, local+".vy-"+stamp+".bak"); err != nil {
+			return action, err
+		}
+	case "overwrite":
+		// Writing through a symlink would change the file it points to, the link itself is replaced
+		if info, err := os.Lstat(local); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(local); err != nil {
+				return action, err
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return action, err
+	}
+	return action, copyFile(staged, local)
+}
+
+// Put back the mode bits and modification time recorded at backup time
+func applyFileMetadata(local string, mf *ManifestFile) error {
+	if err := os.Chmod(local, mf.Mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(local, mf.ModTime, mf.ModTime)
+}
+
+func sameContent(a, b string) bool {
+	x, err := os.ReadFile(a)
+	if err != nil {
+		return false
+	}
+	y, err := os.ReadFile(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(x, y)
+}
+
+func copyFile(src, dst string) error {
+	return copyFileProgress(src, dst, nil)
+}
+
+// Copy src to dst, passing the bytes copied so far to report when it is set
+func copyFileProgress(src, dst string, report func(bytes int64)) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	var w io.Writer = out
+	if report != nil {
+		w = &countingWriter{w: out, report: report}
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// ssh refuses keys readable by others, and rclone does not keep file modes
+func fixSSHPermissions(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.Chmod(path, 0700)
+		}
+		if strings.HasSuffix(path, ".pub") {
+			return os.Chmod(path, 0644)
+		}
+		return os.Chmod(path, 0600)
+	})
+}
+
+func summarizeActions(counts map[string]int) string {
+	actions := make([]string, 0, len(counts))
+	for action, n := range counts {
+		actions = append(actions, fmt.Sprintf("%d %s", n, action))
+	}
+	sort.Strings(actions)
+	return strings.Join(actions, ", ")
+}
+
+// List every file under remotePath, relative to it
+func listFiles(store Storage, remotePath string) ([]string, error) {
+	listing, err := store.List(remotePath, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, f := range listing {
+		if !f.IsDir {
+			files = append(files, f.Path)
+		}
+	}
+	return files, nil
+}
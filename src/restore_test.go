@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestorePath(t *testing.T) {
+	dest := filepath.Join("home", ".config", "foo")
+	tests := []struct {
+		name  string
+		entry *ManifestEntry
+		files []string
+		file  string
+		want  string
+	}{
+		{"single file", &ManifestEntry{Type: entryTypeFile}, []string{"foo"}, "foo", dest},
+		{"directory holding a file of its name", &ManifestEntry{Type: entryTypeDir}, []string{"foo"}, "foo", filepath.Join(dest, "foo")},
+		{"directory", &ManifestEntry{Type: entryTypeDir}, []string{"a", "b/c"}, "b/c", filepath.Join(dest, "b", "c")},
+		{"older manifest, single file", &ManifestEntry{}, []string{"foo"}, "foo", dest},
+		{"no manifest, directory", nil, []string{"a", "foo"}, "a", filepath.Join(dest, "a")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := restorePath(dest, tt.entry, tt.files, tt.file); got != tt.want {
+				t.Errorf("restorePath(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreFileReplacesSymlink(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"staged": "new",
+		"target": "old",
+	})
+	local := filepath.Join(dir, "link")
+	if err := os.Symlink(filepath.Join(dir, "target"), local); err != nil {
+		t.Fatal(err)
+	}
+
+	action, err := restoreFile(filepath.Join(dir, "staged"), local, RestoreOverwrite, "stamp")
+	if err != nil || action != "overwrite" {
+		t.Fatalf("restoreFile = %q, %v, want overwrite", action, err)
+	}
+	if info, err := os.Lstat(local); err != nil || info.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("%s is still a symlink", local)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "target")); string(data) != "old" {
+		t.Errorf("the symlink target was written: %q", data)
+	}
+}
+
+func TestWithLegacyEntries(t *testing.T) {
+	home := filepath.Join("home", "user")
+	tests := []struct {
+		name    string
+		entries []BackupEntry
+		want    []string // remote names and paths
+	}{
+		{"no replacement", []BackupEntry{{Remote: "ssh", Path: "ssh"}}, []string{"ssh ssh"}},
+		{
+			"dconf dump",
+			[]BackupEntry{{Remote: "dconf", Path: "dump"}},
+			[]string{"dconf dump", "dconf-settings " + filepath.Join(home, ".config", "dconf")},
+		},
+		{
+			"profile with its own entry",
+			[]BackupEntry{{Remote: "dconf", Path: "dump"}, {Remote: "dconf-settings", Path: "mine"}},
+			[]string{"dconf dump", "dconf-settings mine"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, entry := range withLegacyEntries(tt.entries, home) {
+				got = append(got, entry.Remote+" "+entry.Path)
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
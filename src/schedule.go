@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Name of the systemd user units written by vy backup schedule
+const scheduleUnit = "vy-backup"
+
+// Where systemd looks for user units, honouring XDG_CONFIG_HOME
+func systemdUserDir(homeDir string) string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "systemd", "user")
+	}
+	return filepath.Join(homeDir, ".config", "systemd", "user")
+}
+
+// Turn --every into an OnCalendar expression, daily and weekly are understood by systemd as they are
+func calendarFor(every string) (string, error) {
+	every = strings.TrimSpace(every)
+	if every == "" {
+		return "", fmt.Errorf("missing schedule, use --every daily, weekly or an OnCalendar expression")
+	}
+	// A newline would start a new line of the timer unit, e.g. a second ExecStart
+	if strings.IndexFunc(every, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("invalid OnCalendar expression: %q", every)
+	}
+	if every == "daily" || every == "weekly" {
+		return every, nil
+	}
+
+	// Let systemd check anything else when it can, rather than failing once the timer is enabled
+	if _, err := exec.LookPath("systemd-analyze"); err == nil {
+		if err := exec.Command("systemd-analyze", "calendar", every).Run(); err != nil {
+			return "", fmt.Errorf("invalid OnCalendar expression: %s", every)
+		}
+	}
+	return every, nil
+}
+
+// Write and enable vy-backup.service and vy-backup.timer, running vy backup with backupArgs
+func HandleSchedule(every string, backupArgs []string) {
+	calendar, err := calendarFor(every)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Printf("Error getting home directory: %v\n", err)
+		return
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Printf("Error finding the vy executable: %v\n", err)
+		return
+	}
+
+	// A timer has no terminal to ask for a passphrase
+	if err := checkScheduledKeys(homeDir, backupArgs); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	execStart := []string{unitQuote(executable), "backup"}
+	for _, arg := range backupArgs {
+		execStart = append(execStart, unitQuote(arg))
+	}
+	service := fmt.Sprintf(`[Unit]
+Description=vy backup of settings and dotfiles
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, strings.Join(execStart, " "))
+
+	timer := fmt.Sprintf(`[Unit]
+Description=Run vy backup %s
+
+[Timer]
+OnCalendar=%s
+Persistent=true
+RandomizedDelaySec=10min
+
+[Install]
+WantedBy=timers.target
+`, every, calendar)
+
+	dir := systemdUserDir(homeDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Printf("Error creating %s: %v\n", dir, err)
+		return
+	}
+	units := map[string]string{
+		scheduleUnit + ".service": service,
+		scheduleUnit + ".timer":   timer,
+	}
+	for name, content := range units {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			fmt.Printf("Error writing %s: %v\n", name, err)
+			return
+		}
+	}
+
+	if err := systemctl("daemon-reload"); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := systemctl("enable", "--now", scheduleUnit+".timer"); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("Backups scheduled (%s): %s\n", calendar, strings.Join(execStart, " "))
+	fmt.Printf("Check them with vy backup schedule status, logs: journalctl --user -u %s.service\n", scheduleUnit)
+}
+
+// Show when the next backup runs and how the last one went
+func HandleScheduleStatus() {
+	homeDir, _ := os.UserHomeDir()
+	if _, err := os.Stat(filepath.Join(systemdUserDir(homeDir), scheduleUnit+".timer")); os.IsNotExist(err) {
+		fmt.Println("No backups are scheduled, use vy backup schedule --every daily")
+		return
+	}
+
+	for _, args := range [][]string{
+		{"list-timers", "--no-pager", scheduleUnit + ".timer"},
+		{"status", "--no-pager", scheduleUnit + ".service"},
+	} {
+		cmd := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		// status exits non-zero when the last run failed or the service is inactive, the output says why
+		cmd.Run()
+		fmt.Println()
+	}
+	fmt.Printf("Full logs: journalctl --user -u %s.service\n", scheduleUnit)
+}
+
+// Disable the timer and delete both units
+func HandleScheduleRemove() {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Printf("Error getting home directory: %v\n", err)
+		return
+	}
+
+	dir := systemdUserDir(homeDir)
+	if _, err := os.Stat(filepath.Join(dir, scheduleUnit+".timer")); os.IsNotExist(err) {
+		fmt.Println("No backups are scheduled")
+		return
+	}
+
+	if err := systemctl("disable", "--now", scheduleUnit+".timer"); err != nil {
+		fmt.Println(err)
+	}
+	for _, name := range []string{scheduleUnit + ".timer", scheduleUnit + ".service"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Error removing %s: %v\n", name, err)
+			return
+		}
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Scheduled backups removed")
+}
+
+// Make sure the scheduled backup can encrypt without asking for a passphrase: either
+// the config has recipients or the passphrase is in the systemd user manager's environment
+func checkScheduledKeys(homeDir string, backupArgs []string) error {
+	profile := ""
+	for i, arg := range backupArgs {
+		switch {
+		case arg == "-f":
+			// A folder is never encrypted
+			return nil
+		case arg == "-p" && i+1 < len(backupArgs):
+			profile = backupArgs[i+1]
+		}
+	}
+	config, entries, err := loadProfile(profile)
+	if err != nil {
+		return err
+	}
+	if len(config.Encryption.Recipients) > 0 {
+		return nil
+	}
+
+	encrypted := false
+	for _, entry := range entries {
+		encrypted = encrypted || entry.encrypted()
+	}
+	if !encrypted {
+		return nil
+	}
+	output, err := exec.Command("systemctl", "--user", "show-environment").Output()
+	if err == nil {
+		for _, line := range strings.Split(string(output), "\n") {
+			if strings.HasPrefix(line, passphraseEnv+"=") && line != passphraseEnv+"=" {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("❌ Encrypted entries need a passphrase, which nobody is there to type when the timer runs.\n"+
+		"   Run vy backup keygen and add the recipient to %s,\n"+
+		"   or set %s in ~/.config/environment.d/ for the systemd user manager", backupConfigPath(homeDir), passphraseEnv)
+}
+
+// Quote an ExecStart argument the way systemd reads it, % specifiers and $ variables are kept literal
+func unitQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	if strings.ContainsAny(arg, " \t\"'\\") || strings.IndexFunc(arg, unicode.IsControl) >= 0 {
+		return strconv.Quote(arg)
+	}
+	return arg
+}
+
+func systemctl(args ...string) error {
+	output, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl error: %w\nOutput: %s", err, output)
+	}
+	return nil
+}
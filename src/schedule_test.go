@@ -0,0 +1,42 @@
+package cmd
+
+import "testing"
+
+func TestCalendarFor(t *testing.T) {
+	tests := []struct {
+		every string
+		want  string
+		ok    bool
+	}{
+		{"daily", "daily", true},
+		{" weekly ", "weekly", true},
+		{"", "", false},
+		{"daily\nExecStartPre=/bin/sh", "", false},
+		{"daily\r", "daily", true}, // trailing whitespace is trimmed first
+		{"Mon\x00", "", false},
+	}
+	for _, tt := range tests {
+		got, err := calendarFor(tt.every)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("calendarFor(%q) = %q, %v, want %q, ok %v", tt.every, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestUnitQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"gdrive:", "gdrive:"},
+		{"my drive:", `"my drive:"`},
+		{"100%", "100%%"},
+		{"$HOME", "$$HOME"},
+		{"x\nExecStartPre=/bin/sh", `"x\nExecStartPre=/bin/sh"`},
+	}
+	for _, tt := range tests {
+		if got := unitQuote(tt.arg); got != tt.want {
+			t.Errorf("unitQuote(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+}
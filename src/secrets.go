@@ -0,0 +1,382 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// What to do when a file about to be uploaded looks like it holds a secret
+const (
+	secretsWarn   = "warn"   // print the findings and upload anyway
+	secretsBlock  = "block"  // print the findings and stop the backup
+	secretsRedact = "redact" // upload a copy with the secrets replaced
+)
+
+// Replaces secrets in the uploaded copy of a file
+const redactedText = "REDACTED"
+
+// Files larger than this are not scanned, dotfiles are much smaller
+const maxSecretScanSize = 1024 * 1024
+
+type SecretsConfig struct {
+	Policy    string `toml:"policy"`    // warn, block or redact, warn by default
+	Allowlist string `toml:"allowlist"` // file of known-safe lines, defaults to ~/.config/vy/secrets.allow
+}
+
+// A line that looks like it holds a secret
+type secretFinding struct {
+	Entry string `json:"entry"`
+	Path  string `json:"path"` // inside the entry, as in the manifest
+	Line  int    `json:"line"`
+	Rule  string `json:"rule"`
+	match string // masked, only printed
+}
+
+type secretRule struct {
+	name    string
+	pattern *regexp.Regexp // the first group, if any, is the secret itself
+}
+
+var secretRules = []secretRule{
+	{"AWS access key", regexp.MustCompile(`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`)},
+	{"AWS secret key", regexp.MustCompile(`(?i)aws_secret_access_key\s*[=:]\s*["']?([A-Za-z0-9/+=]{40})`)},
+	{"GitHub token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+}
+
+var (
+	privateKeyBegin = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+	privateKeyEnd   = regexp.MustCompile(`-----END [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+	// NAME=value in shell rc files, the value is checked for entropy
+	assignment = regexp.MustCompile(`^\s*(?:export\s+)?[A-Za-z_][A-Za-z0-9_]*\s*=\s*["']?([^"'\s;]+)`)
+)
+
+// Shell startup files, where high-entropy assignments are most likely tokens
+func isRcFile(path string) bool {
+	name := filepath.Base(path)
+	switch name {
+	case ".profile", ".bash_profile", ".zprofile", ".zshenv", ".pam_environment", ".xprofile", ".xinitrc", ".env":
+		return true
+	}
+	return (strings.HasPrefix(name, ".") && strings.HasSuffix(name, "rc")) ||
+		strings.HasSuffix(name, "_aliases") || strings.HasSuffix(name, ".sh")
+}
+
+// Bits per character, random tokens score well above words and paths
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// Looks like a generated token rather than a path, a word or a variable
+func highEntropy(value string) bool {
+	if len(value) < 20 || strings.ContainsAny(value, "$:~`(") || strings.HasPrefix(value, "/") {
+		return false
+	}
+	return shannonEntropy(value) >= 3.5
+}
+
+// Keep enough of a secret to recognise it
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return secret[:4] + "****"
+}
+
+// Where the allowlist lives unless the config says otherwise
+func secretsAllowlistPath(homeDir string, config SecretsConfig) string {
+	if config.Allowlist != "" {
+		return expandHome(config.Allowlist, homeDir)
+	}
+	return filepath.Join(filepath.Dir(backupConfigPath(homeDir)), "secrets.allow")
+}
+
+// Known-safe lines, one per line as they appear in the file, # starts a comment
+func loadSecretsAllowlist(path string) (map[string]bool, error) {
+	allow := make(map[string]bool)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return allow, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			allow[line] = true
+		}
+	}
+	return allow, nil
+}
+
+func checkSecretsPolicy(policy string) error {
+	switch policy {
+	case secretsWarn, secretsBlock, secretsRedact:
+		return nil
+	}
+	return fmt.Errorf("unknown secrets policy: %s (use warn, block or redact)", policy)
+}
+
+// Scan a file line by line, returning what was found and the file with every secret replaced
+func scanFile(path string, allow map[string]bool) ([]secretFinding, []byte, error) {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > maxSecretScanSize {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(data, 0) >= 0 {
+		return nil, nil, err
+	}
+
+	rc := isRcFile(path)
+	var findings []secretFinding
+	var redacted bytes.Buffer
+	inKey := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxSecretScanSize)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		out := line
+
+		switch {
+		case allow[strings.TrimSpace(line)]:
+		case inKey:
+			// The key itself, between the BEGIN and END lines
+			if privateKeyEnd.MatchString(line) {
+				inKey = false
+			} else {
+				out = redactedText
+			}
+		case privateKeyBegin.MatchString(line):
+			findings = append(findings, secretFinding{Line: n, Rule: "private key", match: strings.TrimSpace(line)})
+			inKey = !privateKeyEnd.MatchString(line)
+		default:
+			found := false
+			for _, rule := range secretRules {
+				if m := rule.pattern.FindStringSubmatch(line); m != nil {
+					findings = append(findings, secretFinding{Line: n, Rule: rule.name, match: maskSecret(m[1])})
+					out = strings.ReplaceAll(out, m[1], redactedText)
+					found = true
+				}
+			}
+			if m := assignment.FindStringSubmatch(line); !found && rc && m != nil && highEntropy(m[1]) {
+				findings = append(findings, secretFinding{Line: n, Rule: "high-entropy assignment", match: maskSecret(m[1])})
+				out = strings.Replace(out, m[1], redactedText, 1)
+			}
+		}
+		redacted.WriteString(out)
+		redacted.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(findings) == 0 {
+		return nil, nil, nil
+	}
+	// Keep a missing final newline missing
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		redacted.Truncate(redacted.Len() - 1)
+	}
+	return findings, redacted.Bytes(), nil
+}
+
+// Scan the files of every entry that would be uploaded in plain text, keyed by remote name.
+// hash lists the files of an entry, the same way the backup does. When strict, files
+// that are too large or can't be read are findings too, as nobody knows what they hold.
+func scanEntries(targets []BackupEntry, allow map[string]bool, strict bool, hash func(BackupEntry) (ManifestEntry, error)) map[string][]secretFinding {
+	found := make(map[string][]secretFinding)
+	for _, target := range targets {
+		// Encrypted entries never leave the machine readable
+		if target.encrypted() {
+			continue
+		}
+		if _, err := os.Stat(target.Path); err != nil {
+			continue
+		}
+		entry, err := hash(target)
+		if err != nil {
+			continue
+		}
+		root := entryRoot(target.Path)
+		for _, file := range entry.Files {
+			findings, _, err := scanFile(filepath.Join(root, filepath.FromSlash(file.Path)), allow)
+			switch {
+			case err != nil && !strict:
+				continue
+			case err != nil:
+				findings = []secretFinding{{Rule: "unreadable", match: err.Error()}}
+			case strict && file.Size > maxSecretScanSize:
+				findings = []secretFinding{{Rule: "too large to scan", match: formatSize(file.Size)}}
+			}
+			for _, finding := range findings {
+				finding.Entry, finding.Path = target.Name, file.Path
+				found[target.Remote] = append(found[target.Remote], finding)
+			}
+		}
+	}
+	return found
+}
+
+func countFindings(found map[string][]secretFinding) int {
+	count := 0
+	for _, findings := range found {
+		count += len(findings)
+	}
+	return count
+}
+
+// Findings in profile order
+func orderedFindings(targets []BackupEntry, found map[string][]secretFinding) []secretFinding {
+	var findings []secretFinding
+	for _, target := range targets {
+		findings = append(findings, found[target.Remote]...)
+	}
+	return findings
+}
+
+func printFindings(out io.Writer, findings []secretFinding, allowlist string) {
+	fmt.Fprintf(out, "⚠️  %d possible secrets found in files about to be uploaded:\n", len(findings))
+	for _, finding := range findings {
+		// Files that could not be scanned have no line
+		location := finding.Entry + "/" + finding.Path
+		if finding.Line > 0 {
+			location += fmt.Sprintf(":%d", finding.Line)
+		}
+		fmt.Fprintf(out, "    %s  %s  %s\n", location, finding.Rule, finding.match)
+	}
+	fmt.Fprintf(out, "   Add known-safe lines to %s\n\n", allowlist)
+}
+
+// Copy the files of an entry into dir with every secret replaced, keeping modes and times
+func redactEntry(target BackupEntry, entry ManifestEntry, dir string, allow map[string]bool) (BackupEntry, ManifestEntry, error) {
+	root := entryRoot(target.Path)
+	for _, file := range entry.Files {
+		src := filepath.Join(root, filepath.FromSlash(file.Path))
+		dst := filepath.Join(dir, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return target, entry, err
+		}
+
+		_, redacted, err := scanFile(src, allow)
+		if err == nil && redacted != nil {
+			err = os.WriteFile(dst, redacted, file.Mode)
+		} else if err == nil {
+			err = copyFile(src, dst)
+		}
+		if err == nil {
+			err = os.Chtimes(dst, file.ModTime, file.ModTime)
+		}
+		if err != nil {
+			return target, entry, err
+		}
+	}
+
+	// Upload the copy, a single file keeps its name
+	redactedTarget := target
+	redactedTarget.Path = dir
+	if info, err := os.Stat(target.Path); err == nil && !info.IsDir() {
+		redactedTarget.Path = filepath.Join(dir, filepath.Base(target.Path))
+	}
+	redactedTarget.Include, redactedTarget.Exclude = nil, nil
+
+	// The manifest describes what is on the drive, but where it came from
+	redactedEntry, err := buildManifestEntry(redactedTarget, nil)
+	if err != nil {
+		return target, entry, err
+	}
+	redactedEntry.Source = entry.Source
+	return redactedTarget, redactedEntry, nil
+}
+
+// Scan the entries once per run, before anything is uploaded, and apply the policy
+func (l *backupLocal) scanSecrets(targets []BackupEntry, config SecretsConfig, policy, homeDir string, hash func(BackupEntry) (ManifestEntry, error)) error {
+	if l.secrets == nil {
+		if policy == "" {
+			policy = config.Policy
+		}
+		if policy == "" {
+			policy = secretsWarn
+		}
+		if err := checkSecretsPolicy(policy); err != nil {
+			return err
+		}
+		allowlist := secretsAllowlistPath(homeDir, config)
+		allow, err := loadSecretsAllowlist(allowlist)
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %w", allowlist, err)
+		}
+
+		l.secretsPolicy, l.secretsAllow = policy, allow
+		l.secrets = scanEntries(targets, allow, policy == secretsBlock, hash)
+		if len(l.secrets) > 0 {
+			printFindings(l.out, orderedFindings(targets, l.secrets), allowlist)
+			if policy == secretsRedact {
+				fmt.Fprintf(l.out, "Secrets will be replaced with %s in the uploaded copies\n\n", redactedText)
+			}
+		}
+	}
+
+	if l.secretsPolicy == secretsBlock && len(l.secrets) > 0 {
+		return fmt.Errorf("Backup blocked: %d possible secrets found, allow the lines or use --secrets redact", countFindings(l.secrets))
+	}
+	return nil
+}
+
+// Scan a folder uploaded with -f. It goes up as-is, so the redact policy blocks it instead.
+func (l *backupLocal) scanFolder(target BackupEntry, config SecretsConfig, policy, homeDir string) ([]secretFinding, error) {
+	if policy == "" {
+		policy = config.Policy
+	}
+	if policy == secretsRedact {
+		policy = secretsBlock
+	}
+	targets := []BackupEntry{target}
+	err := l.scanSecrets(targets, config, policy, homeDir, func(target BackupEntry) (ManifestEntry, error) {
+		return l.hash(target, nil)
+	})
+	if err != nil && len(l.secrets) > 0 {
+		err = fmt.Errorf("Backup blocked: %d possible secrets found, allow the lines as a folder can't be redacted", countFindings(l.secrets))
+	}
+	return orderedFindings(targets, l.secrets), err
+}
+
+// With the redact policy, what to upload instead of an entry holding secrets
+func (l *backupLocal) redact(target BackupEntry, entry ManifestEntry) (BackupEntry, ManifestEntry, error) {
+	if l.secretsPolicy != secretsRedact || len(l.secrets[target.Remote]) == 0 {
+		return target, entry, nil
+	}
+
+	l.mu.Lock()
+	cached, ok := l.redacted[target.Remote]
+	l.mu.Unlock()
+	if ok {
+		return cached.target, cached.entry, nil
+	}
+
+	dir := filepath.Join(l.stageDir, "redacted", target.Remote)
+	target, entry, err := redactEntry(target, entry, dir, l.secretsAllow)
+	if err != nil {
+		return target, entry, err
+	}
+	l.mu.Lock()
+	l.redacted[target.Remote] = redactedCopy{target: target, entry: entry}
+	l.mu.Unlock()
+	return target, entry, nil
+}
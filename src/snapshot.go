@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Every backup run is written to Backups/ubuntu-settings/hosts/<host>/<snapshot>, named after its start time
+const snapshotLayout = "2006-01-02T15-04-05Z"
+
+// How many snapshots prune keeps; older ones are thinned to one per day, week and month
+type RetentionPolicy struct {
+	Last    int
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+var DefaultRetention = RetentionPolicy{Last: 5, Daily: 7, Weekly: 4, Monthly: 12}
+
+func snapshotName(t time.Time) string {
+	return t.UTC().Format(snapshotLayout)
+}
+
+func parseSnapshotName(name string) (time.Time, bool) {
+	t, err := time.Parse(snapshotLayout, name)
+	return t, err == nil
+}
+
+// List snapshot names in root on the drive, oldest first
+func listSnapshots(store Storage, root string) ([]string, error) {
+	files, err := store.List(root, false)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []string
+	for _, f := range files {
+		// Entries from before snapshots existed live here too, skip them
+		if _, ok := parseSnapshotName(f.Path); ok && f.IsDir {
+			snapshots = append(snapshots, f.Path)
+		}
+	}
+	// The layout sorts chronologically as a string
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// Where the snapshots of host are and their names. With legacy, a host without snapshots
+// falls back to the ones written before hosts existed.
+func hostSnapshots(store Storage, host string, legacy bool) (string, []string, error) {
+	root := hostRoot(host)
+	snapshots, err := listSnapshots(store, root)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(snapshots) == 0 && !legacy {
+		return "", nil, fmt.Errorf("no backups of host %s on %s, see vy backup hosts", host, store)
+	}
+	if len(snapshots) == 0 {
+		root = backupDir
+		if snapshots, err = listSnapshots(store, root); err != nil {
+			return "", nil, err
+		}
+	}
+	return root, snapshots, nil
+}
+
+// Resolve the remote directory to restore from: the requested snapshot of host or its latest one,
+// or the flat layout written before snapshots existed. legacy is passed on to hostSnapshots.
+func resolveSnapshotDir(store Storage, host, snapshot string, legacy bool) (string, error) {
+	root, snapshots, err := hostSnapshots(store, host, legacy)
+	if err != nil {
+		return "", err
+	}
+
+	if snapshot == "" {
+		if len(snapshots) == 0 {
+			return backupDir, nil
+		}
+		return root + "/" + snapshots[len(snapshots)-1], nil
+	}
+
+	for _, name := range snapshots {
+		if name == snapshot {
+			return root + "/" + name, nil
+		}
+	}
+	return "", fmt.Errorf("snapshot %s of host %s not found on %s", snapshot, host, store)
+}
+
+// Pick the snapshots to keep: the newest Last ones, then the newest snapshot of each
+// of the most recent Daily days, Weekly ISO weeks and Monthly months
+func applyRetention(snapshots []string, policy RetentionPolicy) map[string]bool {
+	keep := make(map[string]bool)
+
+	// Newest first, so each bucket keeps its latest snapshot
+	newest := make([]string, len(snapshots))
+	copy(newest, snapshots)
+	sort.Sort(sort.Reverse(sort.StringSlice(newest)))
+
+	for i, name := range newest {
+		if i < policy.Last {
+			keep[name] = true
+		}
+	}
+
+	buckets := []struct {
+		limit int
+		key   func(time.Time) string
+	}{
+		{policy.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{policy.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{policy.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	for _, bucket := range buckets {
+		seen, last := 0, ""
+		for _, name := range newest {
+			if seen >= bucket.limit {
+				break
+			}
+			t, _ := parseSnapshotName(name)
+			if key := bucket.key(t); key != last {
+				keep[name] = true
+				last = key
+				seen++
+			}
+		}
+	}
+	return keep
+}
+
+// Delete snapshots that fall outside the retention policy
+func HandlePrune(policy RetentionPolicy, drive string, dryRun, verbose bool) {
+	store, err := newStorage(drive)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	host, err := configuredHost()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// Only this machine's snapshots, others prune their own
+	root := hostRoot(host)
+	snapshots, err := listSnapshots(store, root)
+	if err != nil {
+		fmt.Printf("Error listing snapshots: %v\n", err)
+		return
+	}
+
+	keep := applyRetention(snapshots, policy)
+
+	// Kept snapshots may point at older ones for entries that did not change. When a manifest
+	// can't be read there is no telling which, so every snapshot before it is kept.
+	used := make(map[string]bool)
+	for _, name := range snapshots {
+		if !keep[name] {
+			continue
+		}
+		manifest, err := fetchManifest(store, root+"/"+name, manifestName)
+		if err != nil {
+			fmt.Printf("⚠️  Could not read the manifest of %s, keeping every older snapshot\n  Error: %v\n", name, err)
+			for _, older := range snapshots {
+				if older < name {
+					used[older] = true
+				}
+			}
+			continue
+		}
+		for _, entry := range manifest.Entries {
+			if entry.StoredIn != "" && !keep[entry.StoredIn] {
+				if verbose && !used[entry.StoredIn] {
+					fmt.Printf("  📎 %s is still used by %s\n", entry.StoredIn, name)
+				}
+				used[entry.StoredIn] = true
+			}
+		}
+	}
+	for name := range used {
+		keep[name] = true
+	}
+
+	removed := 0
+	for _, name := range snapshots {
+		if keep[name] {
+			if verbose {
+				fmt.Printf("  📌 keep   %s\n", name)
+			}
+			continue
+		}
+
+		if dryRun {
+			fmt.Printf("  🗑️  would remove %s\n", name)
+			continue
+		}
+
+		if verbose {
+			fmt.Printf("  🗑️  remove %s... ", name)
+		}
+		if err := store.Delete(root + "/" + name); err != nil {
+			fmt.Printf("❌ Failed to remove %s\n  Error: %v\n\n", name, err)
+			continue
+		}
+		if verbose {
+			fmt.Printf("✅ Done\n")
+		}
+		removed++
+	}
+
+	if dryRun {
+		fmt.Printf("Dry run: %d of %d snapshots would be removed\n", len(snapshots)-len(keep), len(snapshots))
+		return
+	}
+	fmt.Printf("Prune completed! Removed %d of %d snapshots\n", removed, len(snapshots))
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"sort"
+	"testing"
+)
+
+func keptSnapshots(keep map[string]bool) []string {
+	var names []string
+	for name := range keep {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestApplyRetention(t *testing.T) {
+	snapshots := []string{
+		"2024-01-15T10-00-00Z",
+		"2024-02-20T10-00-00Z",
+		"2024-03-04T10-00-00Z", // Monday
+		"2024-03-05T09-00-00Z",
+		"2024-03-05T18-00-00Z",
+		"2024-03-06T08-00-00Z",
+		"2024-03-06T12-00-00Z",
+		"2024-03-06T20-00-00Z",
+	}
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []string
+	}{
+		{
+			name:   "nothing",
+			policy: RetentionPolicy{},
+			want:   nil,
+		},
+		{
+			name:   "last",
+			policy: RetentionPolicy{Last: 2},
+			want:   []string{"2024-03-06T12-00-00Z", "2024-03-06T20-00-00Z"},
+		},
+		{
+			name:   "more last than snapshots",
+			policy: RetentionPolicy{Last: 20},
+			want:   snapshots,
+		},
+		{
+			name:   "daily keeps the newest of each day",
+			policy: RetentionPolicy{Daily: 2},
+			want:   []string{"2024-03-05T18-00-00Z", "2024-03-06T20-00-00Z"},
+		},
+		{
+			name:   "weekly",
+			policy: RetentionPolicy{Weekly: 2},
+			want:   []string{"2024-02-20T10-00-00Z", "2024-03-06T20-00-00Z"},
+		},
+		{
+			name:   "monthly",
+			policy: RetentionPolicy{Monthly: 3},
+			want:   []string{"2024-01-15T10-00-00Z", "2024-02-20T10-00-00Z", "2024-03-06T20-00-00Z"},
+		},
+		{
+			name:   "combined",
+			policy: RetentionPolicy{Last: 1, Daily: 3, Monthly: 2},
+			want:   []string{"2024-02-20T10-00-00Z", "2024-03-04T10-00-00Z", "2024-03-05T18-00-00Z", "2024-03-06T20-00-00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := keptSnapshots(applyRetention(snapshots, tt.policy))
+			if !equalStrings(got, tt.want) {
+				t.Errorf("applyRetention(%+v) = %v, want %v", tt.policy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSnapshotName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"2024-03-06T20-00-00Z", true},
+		{"2024-03-06T20:00:00Z", false},
+		{".bashrc", false},
+		{"manifest.json", false},
+	}
+	for _, tt := range tests {
+		if _, ok := parseSnapshotName(tt.name); ok != tt.ok {
+			t.Errorf("parseSnapshotName(%q) ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
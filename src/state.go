@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// What the last successful backup uploaded to each drive, so unchanged entries can be skipped
+type backupState struct {
+	Drives map[string]map[string]stateEntry `json:"drives"` // drive -> remote entry name -> state
+}
+
+type stateEntry struct {
+	Snapshot string        `json:"snapshot"` // snapshot the entry's data was uploaded to
+	Entry    ManifestEntry `json:"entry"`
+	Keys     string        `json:"keys,omitempty"` // keysFingerprint of an encrypted entry
+}
+
+// Where the state lives, honouring XDG_CACHE_HOME
+func backupStatePath(homeDir string) string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "vy", "backup-state.json")
+	}
+	return filepath.Join(homeDir, ".cache", "vy", "backup-state.json")
+}
+
+// Read the state, starting empty when it is missing or unreadable since it is only a cache
+func loadBackupState(homeDir string) *backupState {
+	state := &backupState{}
+	if data, err := os.ReadFile(backupStatePath(homeDir)); err == nil {
+		json.Unmarshal(data, state)
+	}
+	if state.Drives == nil {
+		state.Drives = make(map[string]map[string]stateEntry)
+	}
+	return state
+}
+
+func (s *backupState) save(homeDir string) error {
+	path := backupStatePath(homeDir)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func (s *backupState) lookup(drive, remote string) *stateEntry {
+	if entry, ok := s.Drives[drive][remote]; ok {
+		return &entry
+	}
+	return nil
+}
+
+func (s *backupState) record(drive, snapshot string, entry ManifestEntry) {
+	if s.Drives[drive] == nil {
+		s.Drives[drive] = make(map[string]stateEntry)
+	}
+	record := stateEntry{Snapshot: snapshot, Entry: entry}
+	if entry.Encryption != nil {
+		record.Keys = keysFingerprint(entry.Encryption.Method, entry.Encryption.Recipients)
+	}
+	s.Drives[drive][entry.Name] = record
+}
+
+// Forget entries whose snapshot is gone from the drive, e.g. removed by hand
+func (s *backupState) forgetMissing(drive string, snapshots []string) {
+	present := make(map[string]bool)
+	for _, name := range snapshots {
+		present[name] = true
+	}
+	for remote, entry := range s.Drives[drive] {
+		if !present[entry.Snapshot] {
+			delete(s.Drives[drive], remote)
+		}
+	}
+}
+
+// An entry is unchanged when it comes from the same place, is stored the same way, encrypted
+// to the same keys (keys is empty for plain entries) and every file still has the same content
+func (e *stateEntry) unchanged(current ManifestEntry, keys string) bool {
+	previous := e.Entry
+	if previous.Error != "" || previous.Source != current.Source || (previous.Encryption != nil) != (keys != "") {
+		return false
+	}
+	// After a key rotation the old objects can't be opened with the new key
+	if e.Keys != keys {
+		return false
+	}
+	if len(previous.Files) != len(current.Files) {
+		return false
+	}
+	for i := range current.Files {
+		a, b := previous.Files[i], current.Files[i]
+		if a.Path != b.Path || a.SHA256 != b.SHA256 || a.Mode != b.Mode {
+			return false
+		}
+	}
+	return true
+}
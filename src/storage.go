@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Where backups are kept. Paths are slash separated and relative to the storage root.
+// Missing paths are reported with an error wrapping os.ErrNotExist.
+type Storage interface {
+	// Copy a local file or directory to remotePath, the way rclone copy does:
+	// a directory's contents end up under remotePath, a file ends up as remotePath/<base name>
+	Put(localPath, remotePath string, filter Filter) error
+	// Copy a remote file or directory into the local directory localPath
+	Get(remotePath, localPath string) error
+	// List what is under remotePath, with paths relative to it
+	List(remotePath string, recursive bool) ([]RemoteFile, error)
+	Mkdir(remotePath string) error
+	Stat(remotePath string) (RemoteFile, error)
+	// Delete a file, or a directory and everything in it
+	Delete(remotePath string) error
+	// SHA-256 of every file under remotePath, keyed by path relative to it
+	Hashes(remotePath string) (map[string]string, error)
+	// The destination as given by the user, e.g. gdrive: or file:///mnt/nas/backups
+	String() string
+}
+
+// Storage that can tell how far an upload got, for the progress of vy backup
+type progressStorage interface {
+	// Put, passing the bytes uploaded so far to progress as they go
+	PutProgress(localPath, remotePath string, filter Filter, progress func(bytes int64)) error
+}
+
+// Put with progress when the storage can report it
+func putProgress(store Storage, localPath, remotePath string, filter Filter, progress func(bytes int64)) error {
+	if ps, ok := store.(progressStorage); ok && progress != nil {
+		return ps.PutProgress(localPath, remotePath, filter, progress)
+	}
+	return store.Put(localPath, remotePath, filter)
+}
+
+type RemoteFile struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// Scheme of destinations stored in a local or mounted directory
+const fileScheme = "file://"
+
+// Turn the -d flag into a destination: a bare remote name like gdrive becomes gdrive:,
+// rclone paths (gdrive:vy) and file:// URIs are kept as they are
+func NormalizeDrive(value string) string {
+	if strings.Contains(value, ":") {
+		return value
+	}
+	return value + ":"
+}
+
+// Open the storage for a destination, checking that it is usable
+func newStorage(drive string) (Storage, error) {
+	return openStorage(drive, defaultTransfer())
+}
+
+// Open the storage for a destination, every operation on it is retried,
+// limited and timed out as t says
+func openStorage(drive string, t *transfer) (Storage, error) {
+	var store Storage
+	var err error
+	if strings.HasPrefix(drive, fileScheme) {
+		store, err = newLocalStorage(drive)
+	} else {
+		store, err = newRcloneStorage(drive, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &retryStorage{store: store, transfer: t}, nil
+}
+
+// Files to leave out of an upload, as globs relative to the uploaded directory
+type Filter struct {
+	Include []string // only these, when set
+	Exclude []string // never these, wins over Include
+	ignore  *ignoreMatcher
+}
+
+func (f Filter) empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0 && f.ignore == nil
+}
+
+// Whether a file, by its slash separated path relative to the uploaded directory, is uploaded
+func (f Filter) allows(rel string) bool {
+	if f.ignore.ignored(rel, false) {
+		return false
+	}
+	for _, pattern := range f.Exclude {
+		if matchGlob(pattern, rel) {
+			return false
+		}
+	}
+	for _, pattern := range f.Include {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return len(f.Include) == 0
+}
+
+// Whether a directory is left out with everything in it, so walks can skip it
+func (f Filter) skipsDir(rel string) bool {
+	return rel != "." && f.ignore.ignored(rel, true)
+}
+
+// Files under the local directory dir that the filter lets through, relative to it
+func (f Filter) files(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() && f.skipsDir(rel) {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() && f.allows(rel) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Match a slash separated path the way rclone filters do: * and ? stay within
+// one path segment, ** crosses segments, and a pattern without a leading /
+// may match the tail of the path
+func matchGlob(pattern, path string) bool {
+	var re strings.Builder
+	if strings.HasPrefix(pattern, "/") {
+		re.WriteString("^")
+		pattern = pattern[1:]
+	} else {
+		re.WriteString("(^|/)")
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	matched, err := regexp.MatchString(re.String(), path)
+	return err == nil && matched
+}
+
+func notFound(path string) error {
+	return fmt.Errorf("%s: %w", path, os.ErrNotExist)
+}
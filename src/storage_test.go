@@ -0,0 +1,57 @@
+package cmd
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.log", "debug.log", true},
+		{"*.log", "logs/debug.log", true},
+		{"*.log", "debug.log.1", false},
+		{"/*.log", "debug.log", true},
+		{"/*.log", "logs/debug.log", false},
+		{"lua/*.lua", "lua/init.lua", true},
+		{"lua/*.lua", "lua/plugins/init.lua", false},
+		{"lua/**", "lua/plugins/init.lua", true},
+		{"**/cache/*", "a/b/cache/file", true},
+		{"id_?sa", "id_rsa", true},
+		{"id_?sa", "id_ecdsa", false},
+		{"config", "ssh/config", true},
+		{"config", "sshconfig", false},
+		{"a+b.txt", "a+b.txt", true},
+		{"a+b.txt", "aab.txt", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFilterAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		path   string
+		want   bool
+	}{
+		{"empty", Filter{}, "anything", true},
+		{"excluded", Filter{Exclude: []string{"*.log"}}, "debug.log", false},
+		{"not excluded", Filter{Exclude: []string{"*.log"}}, "init.lua", true},
+		{"included", Filter{Include: []string{"config"}}, "config", true},
+		{"not included", Filter{Include: []string{"config"}}, "id_rsa", false},
+		{"exclude wins", Filter{Include: []string{"*"}, Exclude: []string{"id_*"}}, "id_rsa", false},
+		{"ignored", Filter{ignore: newIgnoreMatcher("", []string{"*.swp"})}, "undo/file.swp", false},
+		{"inside an ignored directory", Filter{ignore: newIgnoreMatcher("", []string{"node_modules/"})}, "app/node_modules/x/index.js", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.allows(tt.path); got != tt.want {
+				t.Errorf("allows(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,429 @@
+package sysconfig
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An installed package, as recorded in the inventory
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"` // pip, npm and go only, reinstalls use it where it matters
+	Origin  string `json:"origin,omitempty"`  // flatpak remote, e.g. flathub
+	Classic bool   `json:"classic,omitempty"` // snap with classic confinement
+}
+
+// Installed packages keyed by package manager
+type Inventory map[string][]Package
+
+// How to list and install packages of one manager
+type packageManager struct {
+	name    string
+	tool    string // command that must exist for the manager to be used
+	list    func() ([]Package, error)
+	missing func(recorded []Package) ([]Package, error)
+	install func(packages []Package) error
+}
+
+var packageManagers = []packageManager{
+	{"apt", "apt-mark", listApt, missingApt, installApt},
+	{"snap", "snap", listSnap, missingByName(listSnap), installSnap},
+	{"flatpak", "flatpak", listFlatpak, missingByName(listFlatpak), installFlatpak},
+	{"pip", "python3", listPip, missingByName(listPip), installPip},
+	{"npm", "npm", listNpm, missingByName(listNpm), installNpm},
+	{"go", "go", listGo, missingByName(listGo), installGo},
+}
+
+// Names of every supported package manager
+func PackageManagers() []string {
+	names := make([]string, 0, len(packageManagers))
+	for _, m := range packageManagers {
+		names = append(names, m.name)
+	}
+	return names
+}
+
+func findManager(name string) (*packageManager, error) {
+	for i := range packageManagers {
+		if packageManagers[i].name == name {
+			return &packageManagers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown package manager: %s (use %s)", name, strings.Join(PackageManagers(), ", "))
+}
+
+// npm and go may only be installed for the shell, the way setupNode and setupGo leave them
+func (m *packageManager) available() bool {
+	switch m.name {
+	case "npm":
+		return npmCommand().Path != ""
+	case "go":
+		return goCommand() != ""
+	}
+	_, err := exec.LookPath(m.tool)
+	return err == nil
+}
+
+// List the installed packages of the given managers, leaving out the ones not on this machine
+func CaptureInventory(names []string) (Inventory, error) {
+	inventory := make(Inventory)
+	for _, name := range names {
+		m, err := findManager(name)
+		if err != nil {
+			return nil, err
+		}
+		if !m.available() {
+			continue
+		}
+
+		packages, err := m.list()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if packages == nil {
+			packages = []Package{}
+		}
+		// Sorted, so the inventory only changes when the packages do
+		sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+		inventory[name] = packages
+	}
+	return inventory, nil
+}
+
+// Packages of the inventory that are not installed on this machine
+func MissingPackages(inventory Inventory) (Inventory, error) {
+	missing := make(Inventory)
+	for name, recorded := range inventory {
+		m, err := findManager(name)
+		if err != nil {
+			return nil, err
+		}
+		if !m.available() {
+			// Nothing of it can be installed, report all of it
+			missing[name] = recorded
+			continue
+		}
+
+		packages, err := m.missing(recorded)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if len(packages) > 0 {
+			missing[name] = packages
+		}
+	}
+	return missing, nil
+}
+
+// Install the packages of the inventory, carrying on with the other managers when one fails
+func InstallPackages(inventory Inventory) error {
+	var failed []string
+	for _, name := range PackageManagers() {
+		packages := inventory[name]
+		if len(packages) == 0 {
+			continue
+		}
+
+		m, _ := findManager(name)
+		if !m.available() {
+			fmt.Printf("%s is not installed, skipping %d packages\n", m.tool, len(packages))
+			failed = append(failed, name)
+			continue
+		}
+
+		fmt.Printf("\n--------- Installing %d %s packages ---------------\n", len(packages), name)
+		if err := m.install(packages); err != nil {
+			fmt.Printf("Error installing %s packages: %v\n", name, err)
+			failed = append(failed, name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("some packages were not installed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Packages whose name is not in the current list of the manager
+func missingByName(list func() ([]Package, error)) func([]Package) ([]Package, error) {
+	return func(recorded []Package) ([]Package, error) {
+		current, err := list()
+		if err != nil {
+			return nil, err
+		}
+		installed := make(map[string]bool)
+		for _, p := range current {
+			installed[strings.ToLower(p.Name)] = true
+		}
+
+		var missing []Package
+		for _, p := range recorded {
+			if !installed[strings.ToLower(p.Name)] {
+				missing = append(missing, p)
+			}
+		}
+		return missing, nil
+	}
+}
+
+func output(cmd *exec.Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s error: %w\nError: %s", filepath.Base(cmd.Path), err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// Run an install command in the terminal, so sudo can ask for a password
+func run(cmd *exec.Cmd) error {
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func lines(data []byte) []string {
+	var result []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+func names(packages []Package) []string {
+	result := make([]string, 0, len(packages))
+	for _, p := range packages {
+		result = append(result, p.Name)
+	}
+	return result
+}
+
+// Packages installed on purpose, not the ones pulled in as dependencies
+func listApt() ([]Package, error) {
+	data, err := output(exec.Command("apt-mark", "showmanual"))
+	if err != nil {
+		return nil, err
+	}
+	var packages []Package
+	for _, name := range lines(data) {
+		packages = append(packages, Package{Name: name})
+	}
+	return packages, nil
+}
+
+// A manually installed package may be installed as a dependency here, so check dpkg instead
+func missingApt(recorded []Package) ([]Package, error) {
+	data, err := output(exec.Command("dpkg-query", "-W", "-f=${db:Status-Abbrev} ${Package}\n"))
+	if err != nil {
+		return nil, err
+	}
+	installed := make(map[string]bool)
+	for _, line := range lines(data) {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "ii" {
+			installed[fields[1]] = true
+		}
+	}
+
+	var missing []Package
+	for _, p := range recorded {
+		if !installed[p.Name] {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+func installApt(packages []Package) error {
+	return run(exec.Command("sudo", append([]string{"apt", "install", "-y"}, names(packages)...)...))
+}
+
+// Snaps installed by the user, leaving out bases and snapd itself
+func listSnap() ([]Package, error) {
+	data, err := output(exec.Command("snap", "list"))
+	if err != nil {
+		return nil, err
+	}
+	var packages []Package
+	for i, line := range lines(data) {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) < 6 {
+			continue
+		}
+		notes := fields[5]
+		if strings.Contains(notes, "base") || strings.Contains(notes, "core") || strings.Contains(notes, "snapd") {
+			continue
+		}
+		packages = append(packages, Package{Name: fields[0], Classic: strings.Contains(notes, "classic")})
+	}
+	return packages, nil
+}
+
+func installSnap(packages []Package) error {
+	for _, p := range packages {
+		args := []string{"snap", "install", p.Name}
+		if p.Classic {
+			args = append(args, "--classic")
+		}
+		if err := run(exec.Command("sudo", args...)); err != nil {
+			return fmt.Errorf("%s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+func listFlatpak() ([]Package, error) {
+	data, err := output(exec.Command("flatpak", "list", "--app", "--columns=application,origin"))
+	if err != nil {
+		return nil, err
+	}
+	var packages []Package
+	for _, line := range lines(data) {
+		fields := strings.Fields(line)
+		p := Package{Name: fields[0]}
+		if len(fields) > 1 {
+			p.Origin = fields[1]
+		}
+		packages = append(packages, p)
+	}
+	return packages, nil
+}
+
+func installFlatpak(packages []Package) error {
+	for _, p := range packages {
+		origin := p.Origin
+		if origin == "" {
+			origin = "flathub"
+		}
+		if err := run(exec.Command("flatpak", "install", "-y", "--noninteractive", origin, p.Name)); err != nil {
+			return fmt.Errorf("%s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+func listPip() ([]Package, error) {
+	data, err := output(exec.Command("python3", "-m", "pip", "list", "--user", "--format=json"))
+	if err != nil {
+		return nil, err
+	}
+	var packages []Package
+	if err := json.Unmarshal(data, &packages); err != nil {
+		return nil, fmt.Errorf("invalid pip output: %w", err)
+	}
+	return packages, nil
+}
+
+func installPip(packages []Package) error {
+	return run(exec.Command("python3", append([]string{"-m", "pip", "install", "--user"}, names(packages)...)...))
+}
+
+// npm from the PATH, or from nvm the way setupNode installs it
+func npmCommand(args ...string) *exec.Cmd {
+	if path, err := exec.LookPath("npm"); err == nil {
+		return exec.Command(path, args...)
+	}
+	homeDir, _ := os.UserHomeDir()
+	if _, err := os.Stat(filepath.Join(homeDir, ".nvm", "nvm.sh")); err == nil {
+		return exec.Command("bash", append([]string{"-c", `source ~/.nvm/nvm.sh && npm "$@"`, "npm"}, args...)...)
+	}
+	return &exec.Cmd{}
+}
+
+func listNpm() ([]Package, error) {
+	data, err := output(npmCommand("ls", "-g", "--depth=0", "--json"))
+	if err != nil {
+		return nil, err
+	}
+	var tree struct {
+		Dependencies map[string]struct {
+			Version string `json:"version"`
+		} `json:"dependencies"`
+	}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("invalid npm output: %w", err)
+	}
+
+	var packages []Package
+	for name, dep := range tree.Dependencies {
+		// npm itself comes with node
+		if name != "npm" && name != "corepack" {
+			packages = append(packages, Package{Name: name, Version: dep.Version})
+		}
+	}
+	return packages, nil
+}
+
+func installNpm(packages []Package) error {
+	return run(npmCommand(append([]string{"install", "-g"}, names(packages)...)...))
+}
+
+// go from the PATH, or from /usr/local/go where setupGo puts it
+func goCommand() string {
+	if path, err := exec.LookPath("go"); err == nil {
+		return path
+	}
+	if _, err := os.Stat("/usr/local/go/bin/go"); err == nil {
+		return "/usr/local/go/bin/go"
+	}
+	return ""
+}
+
+// Tools in GOBIN (or GOPATH/bin), by the package path they were built from
+func listGo() ([]Package, error) {
+	env, err := output(exec.Command(goCommand(), "env", "GOBIN", "GOPATH"))
+	if err != nil {
+		return nil, err
+	}
+	values := strings.Split(string(env), "\n")
+	dir := strings.TrimSpace(values[0])
+	if dir == "" && len(values) > 1 {
+		dir = filepath.Join(strings.TrimSpace(values[1]), "bin")
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	data, err := output(exec.Command(goCommand(), "version", "-m", dir))
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []Package
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 2 && fields[0] == "path":
+			packages = append(packages, Package{Name: fields[1]})
+		case len(fields) >= 3 && fields[0] == "mod" && len(packages) > 0:
+			packages[len(packages)-1].Version = fields[2]
+		}
+	}
+	return packages, nil
+}
+
+// Reinstall the recorded version, or the latest one for tools built from a local checkout
+func installGo(packages []Package) error {
+	for _, p := range packages {
+		version := p.Version
+		if !strings.HasPrefix(version, "v") {
+			version = "latest"
+		}
+		if err := run(exec.Command(goCommand(), "install", p.Name+"@"+version)); err != nil {
+			return fmt.Errorf("%s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
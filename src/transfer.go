@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+// Defaults for remote operations, used by every command
+const (
+	defaultRetries = 3
+	defaultBackoff = 2 * time.Second
+	maxBackoff     = time.Minute
+)
+
+// [transfer] in backup.toml, flags of vy backup win over it
+type TransferConfig struct {
+	Retries *int   `toml:"retries"` // attempts after the first one, 0 turns retrying off
+	Backoff string `toml:"backoff"` // wait before the first retry, doubled for every next one, e.g. "2s"
+	BWLimit string `toml:"bwlimit"` // rclone --bwlimit, e.g. "1M" or "08:00,512k 19:00,off"
+	Timeout string `toml:"timeout"` // for a whole backup run, e.g. "2h"
+}
+
+// How remote operations are run: retried, limited and stopped when the run times out
+type transfer struct {
+	retries int
+	backoff time.Duration
+	bwlimit string
+	ctx     context.Context
+	log     io.Writer // where retries are announced, stdout when nil
+}
+
+func defaultTransfer() *transfer {
+	return &transfer{retries: defaultRetries, backoff: defaultBackoff, ctx: context.Background()}
+}
+
+// Settings for a backup run from the flags and the config. The returned cancel
+// releases the timeout and must be called once the run is over.
+func newTransfer(opts BackupOptions, config TransferConfig) (*transfer, context.CancelFunc, error) {
+	t := defaultTransfer()
+	if config.Retries != nil {
+		t.retries = *config.Retries
+	}
+	if opts.Retries >= 0 {
+		t.retries = opts.Retries
+	}
+	if config.Backoff != "" {
+		backoff, err := time.ParseDuration(config.Backoff)
+		if err != nil || backoff <= 0 {
+			return nil, nil, fmt.Errorf("invalid transfer backoff: %s", config.Backoff)
+		}
+		t.backoff = backoff
+	}
+	t.bwlimit = config.BWLimit
+	if opts.BWLimit != "" {
+		t.bwlimit = opts.BWLimit
+	}
+
+	timeout := opts.Timeout
+	if timeout == 0 && config.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(config.Timeout); err != nil || timeout <= 0 {
+			return nil, nil, fmt.Errorf("invalid transfer timeout: %s", config.Timeout)
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		t.ctx, cancel = context.WithTimeout(context.Background(), timeout)
+		return t, cancel, nil
+	}
+	return t, func() {}, nil
+}
+
+// Flags added to every rclone command
+func (t *transfer) rcloneFlags() []string {
+	if t.bwlimit == "" {
+		return nil
+	}
+	return []string{"--bwlimit", t.bwlimit}
+}
+
+// Errors worth another attempt, e.g. a dropped connection, rather than a missing file or bad flags
+type temporary interface {
+	Temporary() bool
+}
+
+func isRetryable(err error) bool {
+	var temp temporary
+	return errors.As(err, &temp) && temp.Temporary()
+}
+
+// Wait before attempt n (from 1): exponential with full jitter, so parallel workers don't retry in step
+func (t *transfer) delay(n int) time.Duration {
+	delay := t.backoff << (n - 1)
+	if delay > maxBackoff || delay <= 0 {
+		delay = maxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Run op until it succeeds, fails for good, runs out of retries or the run times out
+func (t *transfer) do(what string, op func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := t.ctx.Err(); err != nil {
+			return fmt.Errorf("backup timed out: %w", err)
+		}
+		err := op()
+		if err == nil || !isRetryable(err) || attempt >= t.retries {
+			return err
+		}
+
+		wait := t.delay(attempt + 1)
+		log := t.log
+		if log == nil {
+			log = os.Stdout
+		}
+		fmt.Fprintf(log, "⚠️  %s failed, retrying in %s (%d of %d): %s\n", what, wait.Round(time.Second/10), attempt+1, t.retries, firstLine(err.Error()))
+		select {
+		case <-time.After(wait):
+		case <-t.ctx.Done():
+		}
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// Storage whose operations all go through a transfer, so every caller gets the same retries
+type retryStorage struct {
+	store    Storage
+	transfer *transfer
+}
+
+// The same storage announcing its retries to w instead, e.g. the log of an entry
+// that is printed once it is done, or the progress so the bar isn't broken up
+func logRetries(store Storage, w io.Writer) Storage {
+	retry, ok := store.(*retryStorage)
+	if !ok {
+		return store
+	}
+	t := *retry.transfer
+	t.log = w
+	return &retryStorage{store: retry.store, transfer: &t}
+}
+
+func (s *retryStorage) String() string {
+	return s.store.String()
+}
+
+func (s *retryStorage) Put(localPath, remotePath string, filter Filter) error {
+	return s.transfer.do("upload to "+remotePath, func() error {
+		return s.store.Put(localPath, remotePath, filter)
+	})
+}
+
+// Each attempt starts the progress over
+func (s *retryStorage) PutProgress(localPath, remotePath string, filter Filter, progress func(bytes int64)) error {
+	return s.transfer.do("upload to "+remotePath, func() error {
+		progress(0)
+		return putProgress(s.store, localPath, remotePath, filter, progress)
+	})
+}
+
+func (s *retryStorage) Get(remotePath, localPath string) error {
+	return s.transfer.do("download of "+remotePath, func() error {
+		return s.store.Get(remotePath, localPath)
+	})
+}
+
+func (s *retryStorage) List(remotePath string, recursive bool) ([]RemoteFile, error) {
+	var files []RemoteFile
+	err := s.transfer.do("listing of "+remotePath, func() error {
+		var err error
+		files, err = s.store.List(remotePath, recursive)
+		return err
+	})
+	return files, err
+}
+
+func (s *retryStorage) Mkdir(remotePath string) error {
+	return s.transfer.do("mkdir "+remotePath, func() error {
+		return s.store.Mkdir(remotePath)
+	})
+}
+
+func (s *retryStorage) Stat(remotePath string) (RemoteFile, error) {
+	var file RemoteFile
+	err := s.transfer.do("stat of "+remotePath, func() error {
+		var err error
+		file, err = s.store.Stat(remotePath)
+		return err
+	})
+	return file, err
+}
+
+func (s *retryStorage) Delete(remotePath string) error {
+	return s.transfer.do("delete of "+remotePath, func() error {
+		return s.store.Delete(remotePath)
+	})
+}
+
+func (s *retryStorage) Hashes(remotePath string) (map[string]string, error) {
+	var hashes map[string]string
+	err := s.transfer.do("hashes of "+remotePath, func() error {
+		var err error
+		hashes, err = s.store.Hashes(remotePath)
+		return err
+	})
+	return hashes, err
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRcloneErrorTemporary(t *testing.T) {
+	for code := 0; code <= 10; code++ {
+		err := &rcloneError{code: code, err: errors.New("exit status")}
+		want := code == 2 || code == 5
+		if got := err.Temporary(); got != want {
+			t.Errorf("exit code %d: Temporary() = %v, want %v", code, got, want)
+		}
+		// Callers wrap what the storage returns
+		if got := isRetryable(fmt.Errorf("upload: %w", err)); got != want {
+			t.Errorf("exit code %d: isRetryable = %v, want %v", code, got, want)
+		}
+	}
+	if isRetryable(errors.New("plain")) || isRetryable(nil) {
+		t.Errorf("an error without Temporary is retryable")
+	}
+}
+
+func TestTransferDelay(t *testing.T) {
+	tr := &transfer{backoff: time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{7, maxBackoff}, // 64s
+		{100, maxBackoff},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := tr.delay(tt.attempt); d < tt.max/2 || d > tt.max {
+				t.Errorf("delay(%d) = %s, want between %s and %s", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestTransferDo(t *testing.T) {
+	temporary := &rcloneError{code: 5, err: errors.New("exit status 5")}
+	fatal := &rcloneError{code: 7, err: errors.New("exit status 7")}
+
+	tests := []struct {
+		name     string
+		retries  int
+		failures []error // returned by the attempts in turn, then success
+		calls    int
+		err      error
+	}{
+		{"first try", 3, nil, 1, nil},
+		{"after two temporary errors", 3, []error{temporary, temporary}, 3, nil},
+		{"out of retries", 2, []error{temporary, temporary, temporary}, 3, temporary},
+		{"retrying off", 0, []error{temporary}, 1, temporary},
+		{"fatal error", 3, []error{fatal}, 1, fatal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log bytes.Buffer
+			tr := &transfer{retries: tt.retries, backoff: time.Millisecond, ctx: context.Background(), log: &log}
+			calls := 0
+			err := tr.do("Upload", func() error {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				return nil
+			})
+			if err != tt.err || calls != tt.calls {
+				t.Errorf("do = %v after %d calls, want %v after %d", err, calls, tt.err, tt.calls)
+			}
+			if retries := strings.Count(log.String(), "retrying"); retries != tt.calls-1 {
+				t.Errorf("%d retries announced, want %d:\n%s", retries, tt.calls-1, log.String())
+			}
+		})
+	}
+}
+
+func TestTransferDoTimedOut(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tr := &transfer{retries: 3, backoff: time.Millisecond, ctx: ctx}
+	called := false
+	err := tr.do("Upload", func() error {
+		called = true
+		return nil
+	})
+	if err == nil || !errors.Is(err, context.Canceled) || called {
+		t.Errorf("do after the timeout = %v, called %v", err, called)
+	}
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Differences between what a manifest says was uploaded and what is on the drive
+type entryDrift struct {
+	Missing    []string // in the manifest, not on the drive
+	Extra      []string // on the drive, not in the manifest
+	Mismatched []string // on the drive with a different hash
+	Ignored    []string // extra files the ignore rules leave out, so they should never have been uploaded
+}
+
+func (d entryDrift) ok() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Mismatched) == 0 && len(d.Ignored) == 0
+}
+
+// Move the extra files that filter ignores to Ignored
+func (d *entryDrift) splitIgnored(filter Filter) {
+	var extra []string
+	for _, path := range d.Extra {
+		if filter.ignore.ignored(path, false) {
+			d.Ignored = append(d.Ignored, path)
+		} else {
+			extra = append(extra, path)
+		}
+	}
+	d.Extra = extra
+}
+
+// Compare the expected hashes of files with the ones on the drive
+func compareHashes(expected, actual map[string]string) entryDrift {
+	var drift entryDrift
+	for path, sum := range expected {
+		remote, ok := actual[path]
+		switch {
+		case !ok:
+			drift.Missing = append(drift.Missing, path)
+		case sum != "" && remote != sum:
+			drift.Mismatched = append(drift.Mismatched, path)
+		}
+	}
+	for path := range actual {
+		if _, ok := expected[path]; !ok {
+			drift.Extra = append(drift.Extra, path)
+		}
+	}
+	sort.Strings(drift.Missing)
+	sort.Strings(drift.Extra)
+	sort.Strings(drift.Mismatched)
+	return drift
+}
+
+// What a manifest entry should have put on the drive, keyed by path inside its directory.
+// An empty hash only checks that the file is there.
+func expectedHashes(entry *ManifestEntry) map[string]string {
+	expected := make(map[string]string)
+	if entry.Encryption != nil {
+		expected[entry.Encryption.Object] = entry.Encryption.SHA256
+		return expected
+	}
+	for _, f := range entry.Files {
+		expected[f.Path] = f.SHA256
+	}
+	return expected
+}
+
+type VerifyOptions struct {
+	Drive    string
+	Verbose  bool
+	Snapshot string // snapshot to check, empty means the latest one
+	Profile  string // profile whose ignore rules tell ignored files, same as for backup
+	Host     string // machine whose backup to check, empty means this one
+}
+
+// Check a snapshot on the drive against its manifest, returning false on any drift
+func HandleVerify(opts VerifyOptions) bool {
+	drive, verbose := opts.Drive, opts.Verbose
+	store, err := newStorage(drive)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	config, entries, err := loadProfile(opts.Profile)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	host, err := currentHost(config.Host)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	legacy := opts.Host == "" || opts.Host == host
+	if !legacy {
+		fmt.Printf("⚠️  Verifying the backup of host %s, not of this machine (%s)\n", opts.Host, host)
+		host = opts.Host
+	}
+	snapshotDir, err := resolveSnapshotDir(store, host, opts.Snapshot, legacy)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	manifest, err := fetchManifest(store, snapshotDir, manifestName)
+	if err != nil {
+		fmt.Printf("❌ No manifest found in %s on %s, nothing to verify against\n  Error: %v\n", snapshotDir, drive, err)
+		return false
+	}
+
+	fmt.Printf("Verifying %s on %s\n\n", snapshotDir, drive)
+
+	// Ignore rules of the entries on this machine, to tell files that should have been left out
+	filters := make(map[string]Filter)
+	for _, target := range entries {
+		filters[target.Remote] = target.filter()
+	}
+
+	// A snapshot written with --archive is a single object
+	if manifest.Archive != "" {
+		actual, err := store.Hashes(snapshotDir)
+		if err != nil {
+			fmt.Printf("❌ Failed to read hashes\n  Error: %v\n", err)
+			return false
+		}
+		delete(actual, manifestName)
+		drift := compareHashes(map[string]string{manifest.Archive: manifest.ArchiveSHA256}, actual)
+		printDrift(manifest.Archive, drift, 1)
+		if !drift.ok() {
+			fmt.Printf("\nVerify failed! %s differs from the manifest\n", manifest.Archive)
+			return false
+		}
+		fmt.Printf("\nVerify completed! %s matches the manifest\n", manifest.Archive)
+		return true
+	}
+
+	drifted := 0
+	for i := range manifest.Entries {
+		entry := &manifest.Entries[i]
+		if entry.Error != "" {
+			fmt.Printf("❌ %s: failed during backup\n  Error: %s\n", entry.Name, entry.Error)
+			drifted++
+			continue
+		}
+
+		remoteDir := snapshotDir + "/" + entry.Name
+		if entry.StoredIn != "" {
+			remoteDir = storedInDir(snapshotDir, entry.StoredIn) + "/" + entry.Name
+		}
+		if verbose {
+			fmt.Printf("🔍 %s (%s)\n", entry.Name, remoteDir)
+		}
+
+		expected := expectedHashes(entry)
+		actual, err := store.Hashes(remoteDir)
+		if errors.Is(err, os.ErrNotExist) {
+			actual = map[string]string{}
+		} else if err != nil {
+			fmt.Printf("❌ %s: failed to read hashes\n  Error: %v\n", entry.Name, err)
+			drifted++
+			continue
+		}
+		drift := compareHashes(expected, actual)
+		if entry.Encryption == nil {
+			drift.splitIgnored(filters[entry.Name])
+		}
+		printDrift(entry.Name, drift, len(expected))
+		if !drift.ok() {
+			drifted++
+		}
+	}
+
+	if drifted > 0 {
+		fmt.Printf("\nVerify failed! %d of %d configurations differ from the manifest\n", drifted, len(manifest.Entries))
+		return false
+	}
+	fmt.Printf("\nVerify completed! All %d configurations match the manifest\n", len(manifest.Entries))
+	return true
+}
+
+func printDrift(name string, drift entryDrift, total int) {
+	if drift.ok() {
+		fmt.Printf("✅ %s: %d files ok\n", name, total)
+		return
+	}
+
+	fmt.Printf("❌ %s: %d missing, %d extra, %d mismatched", name, len(drift.Missing), len(drift.Extra), len(drift.Mismatched))
+	if len(drift.Ignored) > 0 {
+		fmt.Printf(", %d ignored", len(drift.Ignored))
+	}
+	fmt.Println()
+	for _, path := range drift.Missing {
+		fmt.Printf("    missing    %s\n", path)
+	}
+	for _, path := range drift.Extra {
+		fmt.Printf("    extra      %s\n", path)
+	}
+	for _, path := range drift.Mismatched {
+		fmt.Printf("    mismatched %s\n", path)
+	}
+	for _, path := range drift.Ignored {
+		fmt.Printf("    ignored    %s\n", path)
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareHashes(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected map[string]string
+		actual   map[string]string
+		want     entryDrift
+	}{
+		{"same", map[string]string{"a": "1", "b/c": "2"}, map[string]string{"a": "1", "b/c": "2"}, entryDrift{}},
+		{"empty", map[string]string{}, map[string]string{}, entryDrift{}},
+		{"missing", map[string]string{"a": "1", "b": "2"}, map[string]string{"a": "1"}, entryDrift{Missing: []string{"b"}}},
+		{"extra", map[string]string{"a": "1"}, map[string]string{"a": "1", "z": "9", "m": "5"}, entryDrift{Extra: []string{"m", "z"}}},
+		{"mismatched", map[string]string{"a": "1", "b": "2"}, map[string]string{"a": "x", "b": "2"}, entryDrift{Mismatched: []string{"a"}}},
+		{"no expected hash", map[string]string{"a": ""}, map[string]string{"a": "anything"}, entryDrift{}},
+		{
+			"everything",
+			map[string]string{"gone": "1", "changed": "2"},
+			map[string]string{"changed": "3", "new": "4"},
+			entryDrift{Missing: []string{"gone"}, Extra: []string{"new"}, Mismatched: []string{"changed"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareHashes(tt.expected, tt.actual)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compareHashes = %+v, want %+v", got, tt.want)
+			}
+			if got.ok() != reflect.DeepEqual(tt.want, entryDrift{}) {
+				t.Errorf("ok() = %v for %+v", got.ok(), got)
+			}
+		})
+	}
+}
+
+func TestSplitIgnored(t *testing.T) {
+	drift := entryDrift{Extra: []string{"debug.log", "init.lua", "undo/x.swp"}}
+	drift.splitIgnored(Filter{ignore: newIgnoreMatcher("", []string{"*.log", "*.swp"})})
+	if !equalStrings(drift.Extra, []string{"init.lua"}) || !equalStrings(drift.Ignored, []string{"debug.log", "undo/x.swp"}) {
+		t.Errorf("extra %v, ignored %v", drift.Extra, drift.Ignored)
+	}
+	if drift.ok() {
+		t.Errorf("ignored files count as ok")
+	}
+}